@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default values for adaptive pool config.
+const (
+	DefaultAdaptiveMinOpenConnections = 1
+	DefaultAdaptiveInterval           = time.Second * 5
+	DefaultAdaptiveTargetWait         = time.Millisecond * 10
+)
+
+// AdaptiveConfig for set properties of adaptive pool controller, see SQL.Run.
+type AdaptiveConfig struct {
+	// MinOpenConnections is a lower bound of pool size,
+	// by default DefaultAdaptiveMinOpenConnections.
+	MinOpenConnections int
+	// MaxOpenConnections is an upper bound of pool size,
+	// by default SQLConfig.SetMaxOpenConnections.
+	MaxOpenConnections int
+	// Interval between pool size adjustments, by default DefaultAdaptiveInterval.
+	Interval time.Duration
+	// TargetWait is a maximum average wait for connection, pool grows if
+	// calls wait longer and shrinks if they don't wait at all and most
+	// connections aren't used. By default DefaultAdaptiveTargetWait.
+	TargetWait time.Duration
+	// MaxWaiting enables load shedding: new DAL calls fail with
+	// *OverloadedError without waiting for connection if pool is exhausted
+	// and at least MaxWaiting calls wait for connection already.
+	// Only SQL.Tx and SQL.Conn calls are counted as waiting, because
+	// statements of SQL.NoTx acquire connections inside database/sql.
+	MaxWaiting int
+}
+
+func (c AdaptiveConfig) setDefault(maxOpenConnections int) AdaptiveConfig {
+	if c.MinOpenConnections == 0 {
+		c.MinOpenConnections = DefaultAdaptiveMinOpenConnections
+	}
+	if c.MaxOpenConnections == 0 {
+		c.MaxOpenConnections = maxOpenConnections
+	}
+	if c.MaxOpenConnections < c.MinOpenConnections {
+		c.MaxOpenConnections = c.MinOpenConnections
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultAdaptiveInterval
+	}
+	if c.TargetWait == 0 {
+		c.TargetWait = DefaultAdaptiveTargetWait
+	}
+	return c
+}
+
+// PoolDecision is a change of pool size made by adaptive pool controller.
+type PoolDecision uint8
+
+// Enum.
+const (
+	_          PoolDecision = iota
+	PoolGrow                // grow
+	PoolShrink              // shrink
+)
+
+// OverloadedError is returned as DALError.Err by DAL methods which are
+// shed because too many calls wait for connection (see AdaptiveConfig.MaxWaiting).
+type OverloadedError struct {
+	// Waiting is an amount of calls waiting for connection.
+	Waiting int
+}
+
+// Error implements error.
+func (e *OverloadedError) Error() string {
+	return fmt.Sprintf("overloaded: %d calls wait for connection", e.Waiting)
+}
+
+// adaptive is an adaptive pool controller, it's the only one which sets
+// maximum of open and idle connections of pool if SQLConfig.Adaptive is set.
+type adaptive struct {
+	cfg AdaptiveConfig
+
+	mu   sync.Mutex
+	size int
+	// pool is observed by last tick, prev are its statistics.
+	pool *pool
+	prev sql.DBStats
+	// pendingWaits are started waits which duration isn't known yet,
+	// because sql.DBStats.WaitDuration grows when wait is finished.
+	pendingWaits int64
+}
+
+func newAdaptive(cfg AdaptiveConfig, size int) *adaptive {
+	return &adaptive{
+		cfg:  cfg,
+		size: min(max(size, cfg.MinOpenConnections), cfg.MaxOpenConnections),
+	}
+}
+
+// apply sets current size to pool.
+func (a *adaptive) apply(p *pool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p.conn.SetMaxOpenConns(a.size)
+	p.conn.SetMaxIdleConns(a.size)
+}
+
+// tick adjusts size of pool based on its statistics since previous tick.
+// Controller is re-seeded if pool is replaced by SQL.Reconfigure.
+func (a *adaptive) tick(p *pool) (int, PoolDecision) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if p != a.pool {
+		// Statistics of new pool start from zero.
+		a.pool, a.prev, a.pendingWaits = p, sql.DBStats{}, 0
+	}
+
+	stats := p.conn.Stats()
+
+	waits := stats.WaitCount - a.prev.WaitCount + a.pendingWaits
+	wait := stats.WaitDuration - a.prev.WaitDuration
+	a.prev = stats
+	a.pendingWaits = 0
+
+	size, decision := a.size, PoolDecision(0)
+	switch {
+	case waits > 0 && wait == 0:
+		a.pendingWaits = waits
+	case waits > 0 && wait/time.Duration(waits) > a.cfg.TargetWait && size < a.cfg.MaxOpenConnections:
+		size, decision = min(a.cfg.MaxOpenConnections, size+max(1, size/2)), PoolGrow
+	case waits == 0 && stats.InUse*2 < size && size > a.cfg.MinOpenConnections:
+		size, decision = max(a.cfg.MinOpenConnections, size-max(1, size/4)), PoolShrink
+	}
+	if decision != 0 {
+		a.size = size
+		p.conn.SetMaxOpenConns(size)
+		p.conn.SetMaxIdleConns(size)
+	}
+	return size, decision
+}
+
+// Run adjusts size of pool according to SQLConfig.Adaptive until ctx is
+// done, it returns immediately if SQLConfig.Adaptive is nil.
+func (db *SQL) Run(ctx context.Context) error {
+	if db.adaptive == nil {
+		return nil
+	}
+
+	t := time.NewTicker(db.adaptive.cfg.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+
+		size, decision := db.adaptive.tick(db.pool.Load())
+		if decision != 0 {
+			db.poolMetrics.PoolResized(size, decision)
+		}
+	}
+}
+
+// shed returns *OverloadedError if call must not wait for connection.
+func (db *SQL) shed(c *call) error {
+	if db.adaptive == nil || db.adaptive.cfg.MaxWaiting == 0 {
+		return nil
+	}
+
+	stats := c.pool.conn.Stats()
+	if stats.InUse < stats.MaxOpenConnections {
+		return nil
+	}
+	waiting := int(c.pool.waiting.Load())
+	if waiting < db.adaptive.cfg.MaxWaiting {
+		return nil
+	}
+
+	db.poolMetrics.Shed(c.method)
+	return &OverloadedError{Waiting: waiting}
+}
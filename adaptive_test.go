@@ -0,0 +1,143 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/connectors"
+)
+
+func TestSQL_Run(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reg := prometheus.NewPedanticRegistry()
+	db, mock := start(t, database.SQLConfig{
+		Metrics:               database.NewMetrics(reg, "test", "db"),
+		SetMaxOpenConnections: 1,
+		Adaptive: &database.AdaptiveConfig{
+			MaxOpenConnections: 3,
+			Interval:           time.Millisecond * 20,
+			TargetWait:         time.Millisecond,
+		},
+	})
+	errc := make(chan error)
+	go func() { errc <- db.Run(ctx) }()
+
+	// Second call waits for connection used by first one.
+	mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	update := func() error {
+		return db.Conn(ctx, func(conn *sqlx.Conn) error {
+			time.Sleep(time.Millisecond * 10)
+			_, err := conn.ExecContext(ctx, "update")
+			return err
+		})
+	}
+	go func() { errc <- update() }()
+	r.NoError(update())
+	r.NoError(<-errc)
+	r.NoError(mock.ExpectationsWereMet())
+
+	grown := func() bool {
+		return metricValue(t, reg, "test_db_pool_resized_total", prometheus.Labels{"decision": "grow"}) == 1
+	}
+	r.Eventually(grown, time.Second, time.Millisecond)
+	// Pool isn't used, so it shrinks back.
+	r.Eventually(func() bool { return db.Stats().MaxOpenConnections == 1 }, time.Second, time.Millisecond)
+	r.Equal(1.0, metricValue(t, reg, "test_db_pool_resized_total", prometheus.Labels{"decision": "shrink"}))
+	r.Equal(1.0, metricValue(t, reg, "test_db_pool_max_open_connections", nil))
+
+	cancel()
+	r.NoError(<-errc)
+}
+
+func TestSQL_Shed(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	reg := prometheus.NewPedanticRegistry()
+	db, mock := start(t, database.SQLConfig{
+		Metrics:               database.NewMetrics(reg, "test", "db"),
+		SetMaxOpenConnections: 1,
+		Adaptive:              &database.AdaptiveConfig{MaxWaiting: 1},
+	})
+	mock.ExpectBegin()
+	mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	release, errc := make(chan struct{}), make(chan error)
+	go func() {
+		errc <- db.Conn(ctx, func(*sqlx.Conn) error {
+			<-release
+			return nil
+		})
+	}()
+	r.Eventually(func() bool { return db.Stats().InUse == 1 }, time.Second, time.Millisecond)
+	// Calls which don't wait for connection aren't shed.
+	r.NoError(db.NoTx(func(*sqlx.DB) error { return nil }))
+
+	go func() {
+		errc <- db.Tx(ctx, nil, func(tx *sqlx.Tx) error {
+			_, err := tx.ExecContext(ctx, "update")
+			return err
+		})
+	}()
+	r.Eventually(func() bool { return db.Stats().WaitCount == 1 }, time.Second, time.Millisecond)
+
+	err := db.NoTx(func(*sqlx.DB) error { return nil })
+	overloaded := &database.OverloadedError{}
+	r.True(errors.As(err, &overloaded))
+	r.Equal(1, overloaded.Waiting)
+	dalErr := &database.DALError{}
+	r.True(errors.As(err, &dalErr))
+	r.Equal(database.PhaseAdmit, dalErr.Phase)
+	r.Equal(1.0, metricValue(t, reg, "test_db_shed_total", nil))
+
+	close(release)
+	r.NoError(<-errc)
+	r.NoError(<-errc)
+	r.NoError(mock.ExpectationsWereMet())
+}
+
+func TestSQL_ReconfigureAdaptive(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	dsn := t.Name() + time.Now().String()
+	_, mock, err := sqlmock.NewWithDSN(dsn)
+	r.NoError(err)
+	cfg := database.SQLConfig{
+		SetMaxOpenConnections: 10,
+		Adaptive:              &database.AdaptiveConfig{MaxOpenConnections: 3},
+	}
+	db, err := database.NewSQL(ctx, "sqlmock", cfg, &connectors.Raw{Query: dsn})
+	r.NoError(err)
+	r.Equal(3, db.Stats().MaxOpenConnections)
+
+	// Size is set by controller only, new pool starts with its size.
+	r.NoError(db.Reconfigure(ctx, cfg, &connectors.Raw{Query: dsn}))
+	r.Equal(3, db.Stats().MaxOpenConnections)
+
+	newDSN := dsn + "new"
+	_, newMock, err := sqlmock.NewWithDSN(newDSN)
+	r.NoError(err)
+	mock.ExpectClose()
+	r.NoError(db.Reconfigure(ctx, cfg, &connectors.Raw{Query: newDSN}))
+	r.Equal(3, db.Stats().MaxOpenConnections)
+
+	newMock.ExpectClose()
+	r.NoError(db.Close())
+}
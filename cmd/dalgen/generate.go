@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const databasePath = "github.com/sipki-tech/database"
+
+// Errors.
+var (
+	ErrNotFound     = errors.New("type not found")
+	ErrNotInterface = errors.New("type is not an interface")
+	ErrUnsupported  = errors.New("unsupported interface")
+)
+
+var tmpl = template.Must(template.New("").Parse(`// Code generated by dalgen. DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+{{- range .StdImports }}
+	{{ . }}
+{{- end }}
+{{ range .Imports }}
+	{{ . }}
+{{- end }}
+)
+
+// {{ .Type }}Methods contains names of {{ .Type }} methods, use it as
+// database.MetricsConfig.Methods or database.Metrics.AddMethods for labels
+// pre-initialization.
+var {{ .Type }}Methods = []string{
+{{- range .Methods }}
+	{{ printf "%q" .Name }},
+{{- end }}
+}
+
+// {{ .Type }}WithMetrics is a {{ .Type }} which collects metrics for every call.
+type {{ .Type }}WithMetrics struct {
+	next    {{ .Type }}
+	metrics database.MetricCollector
+}
+
+var _ {{ .Type }} = {{ .Type }}WithMetrics{}
+
+// New{{ .Type }}WithMetrics returns {{ .Type }} which calls next using metrics.
+func New{{ .Type }}WithMetrics(next {{ .Type }}, metrics database.MetricCollector) {{ .Type }}WithMetrics {
+	return {{ .Type }}WithMetrics{next: next, metrics: metrics}
+}
+{{ range .Methods }}
+// {{ .Name }} implements {{ $.Type }}.
+func (w {{ $.Type }}WithMetrics) {{ .Name }}({{ .Params }}) {{ .Results }} {
+{{- if and .HasErr (not .Vars) }}
+	return w.metrics.Collecting({{ printf "%q" .Name }}, func() error {
+		return w.next.{{ .Name }}({{ .Args }})
+	})()
+{{- else }}
+{{- range .Vars }}
+	var {{ . }}
+{{- end }}
+	{{ if .HasErr }}err :={{ else }}_ ={{ end }} w.metrics.Collecting({{ printf "%q" .Name }}, func() error {
+{{- if .HasErr }}
+		var err error
+		{{ .Assign }} = w.next.{{ .Name }}({{ .Args }})
+		return err
+{{- else }}
+		{{ if .Vars }}{{ .Assign }} = {{ end }}w.next.{{ .Name }}({{ .Args }})
+		return nil
+{{- end }}
+	})()
+{{- if .Vars }}
+	return {{ .Return }}
+{{- end }}
+{{- end }}
+}
+{{ end -}}
+`))
+
+type templateData struct {
+	Package    string
+	StdImports []string
+	Imports    []string
+	Type       string
+	Methods    []method
+}
+
+type method struct {
+	Name    string
+	Params  string // Declaration of parameters.
+	Args    string // Arguments for call of wrapped method.
+	Results string // Declaration of results.
+	Vars    []string
+	Assign  string // Left side of assignment from wrapped method call.
+	Return  string
+	HasErr  bool
+}
+
+// generate returns source of wrapper for interface typeName declared in
+// package inside dir.
+func generate(dir, typeName string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, iface, err := findInterface(fset, dir, typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	data := templateData{
+		Package: file.Name.Name,
+		Type:    typeName,
+	}
+	usedPkgs := make(map[string]bool)
+	for _, field := range iface.Methods.List {
+		if len(field.Names) == 0 {
+			return nil, fmt.Errorf("%w: embedded interface %s", ErrUnsupported, types.ExprString(field.Type))
+		}
+		ast.Inspect(field.Type, func(node ast.Node) bool {
+			if sel, ok := node.(*ast.SelectorExpr); ok {
+				if ident, ok := sel.X.(*ast.Ident); ok {
+					usedPkgs[ident.Name] = true
+				}
+			}
+			return true
+		})
+		for _, name := range field.Names {
+			data.Methods = append(data.Methods, newMethod(name.Name, field.Type.(*ast.FuncType)))
+		}
+	}
+
+	data.Imports = []string{strconv.Quote(databasePath)}
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := importName(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if !usedPkgs[name] || path == databasePath {
+			continue
+		}
+		imp := spec.Path.Value
+		if spec.Name != nil {
+			imp = spec.Name.Name + " " + imp
+		}
+		if strings.Contains(strings.Split(path, "/")[0], ".") {
+			data.Imports = append(data.Imports, imp)
+		} else {
+			data.StdImports = append(data.StdImports, imp)
+		}
+	}
+	sort.Strings(data.StdImports)
+	sort.Strings(data.Imports)
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return nil, fmt.Errorf("tmpl.Execute: %w", err)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format.Source: %w", err)
+	}
+	return src, nil
+}
+
+func findInterface(fset *token.FileSet, dir, typeName string) (*ast.File, *ast.InterfaceType, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("filepath.Glob: %w", err)
+	}
+
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(path) //nolint:gosec // Path is from user.
+		if err != nil {
+			return nil, nil, fmt.Errorf("os.ReadFile: %w", err)
+		}
+		file, err := parser.ParseFile(fset, path, src, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parser.ParseFile: %w", err)
+		}
+
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				if typeSpec.Name.Name != typeName {
+					continue
+				}
+				iface, ok := typeSpec.Type.(*ast.InterfaceType)
+				switch {
+				case !ok:
+					return nil, nil, fmt.Errorf("%w: %s", ErrNotInterface, typeName)
+				case typeSpec.TypeParams != nil:
+					return nil, nil, fmt.Errorf("%w: generic interface %s", ErrUnsupported, typeName)
+				}
+				return file, iface, nil
+			}
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%w: %s", ErrNotFound, typeName)
+}
+
+var (
+	reservedName = regexp.MustCompile(`^(w|err|r\d+)$`)
+	majorVersion = regexp.MustCompile(`^v\d+$`)
+)
+
+func newMethod(name string, typ *ast.FuncType) method {
+	m := method{Name: name}
+
+	// Renamed parameters must not collide with kept ones.
+	used := make(map[string]bool)
+	for _, field := range typ.Params.List {
+		for _, ident := range field.Names {
+			used[ident.Name] = true
+		}
+	}
+	rename := func(i int) string {
+		for ; ; i++ {
+			name := fmt.Sprintf("p%d", i)
+			if !used[name] && !reservedName.MatchString(name) {
+				used[name] = true
+				return name
+			}
+		}
+	}
+
+	var params, args []string
+	for _, field := range typ.Params.List {
+		names := field.Names
+		if len(names) == 0 {
+			names = []*ast.Ident{{}}
+		}
+		for _, ident := range names {
+			paramName := ident.Name
+			if paramName == "" || paramName == "_" || reservedName.MatchString(paramName) {
+				paramName = rename(len(params))
+			}
+			params = append(params, paramName+" "+types.ExprString(field.Type))
+			if _, ok := field.Type.(*ast.Ellipsis); ok {
+				paramName += "..."
+			}
+			args = append(args, paramName)
+		}
+	}
+	m.Params = strings.Join(params, ", ")
+	m.Args = strings.Join(args, ", ")
+
+	var results, returns []string
+	if typ.Results != nil {
+		for _, field := range typ.Results.List {
+			n := len(field.Names)
+			if n == 0 {
+				n = 1
+			}
+			for i := 0; i < n; i++ {
+				results = append(results, types.ExprString(field.Type))
+			}
+		}
+	}
+	for i, result := range results {
+		if i == len(results)-1 && result == "error" {
+			m.HasErr = true
+			returns = append(returns, "err")
+			continue
+		}
+		varName := fmt.Sprintf("r%d", i)
+		m.Vars = append(m.Vars, varName+" "+result)
+		returns = append(returns, varName)
+	}
+	m.Assign = strings.Join(returns, ", ")
+	m.Return = m.Assign
+
+	switch len(results) {
+	case 0:
+	case 1:
+		m.Results = results[0]
+	default:
+		m.Results = "(" + strings.Join(results, ", ") + ")"
+	}
+	return m
+}
+
+// importName returns default package name for import path.
+func importName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && majorVersion.MatchString(name) {
+		name = elems[len(elems)-2]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	if pos := strings.IndexByte(name, '.'); pos != -1 {
+		name = name[:pos]
+	}
+	return strings.ReplaceAll(name, "-", "_")
+}
@@ -0,0 +1,52 @@
+//nolint:testpackage // Testing unexported function.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	got, err := generate(filepath.Join("testdata", "repo"), "Repo")
+	r.NoError(err)
+
+	golden := filepath.Join("testdata", "repo_metrics.go.golden")
+	if *update {
+		r.NoError(os.WriteFile(golden, got, 0o600))
+	}
+	want, err := os.ReadFile(golden)
+	r.NoError(err)
+	r.Equal(string(want), string(got))
+
+	typeCheck(t, filepath.Join("testdata", "repo"), got)
+}
+
+// typeCheck checks that generated source compiles together with package in dir.
+func typeCheck(t *testing.T, dir string, src []byte) {
+	t.Helper()
+	r := require.New(t)
+
+	tmp := t.TempDir()
+	generated := filepath.Join(tmp, "generated.go")
+	r.NoError(os.WriteFile(generated, src, 0o600))
+	target, err := filepath.Abs(filepath.Join(dir, "generated.go"))
+	r.NoError(err)
+	overlay, err := json.Marshal(map[string]map[string]string{"Replace": {target: generated}})
+	r.NoError(err)
+	overlayPath := filepath.Join(tmp, "overlay.json")
+	r.NoError(os.WriteFile(overlayPath, overlay, 0o600))
+
+	out, err := exec.Command("go", "build", "-overlay", overlayPath, "./"+filepath.ToSlash(dir)).CombinedOutput()
+	r.NoError(err, string(out))
+}
@@ -0,0 +1,51 @@
+// Command dalgen generates wrapper for repository interface which
+// collects metrics for every method call using database.MetricCollector.
+//
+// Unlike SQL.NoTx and SQL.Tx generated wrapper has method names baked in,
+// so it doesn't use runtime caller inspection nor reflection.
+//
+// Usage:
+//
+//	//go:generate go run github.com/sipki-tech/database/cmd/dalgen -type=Repo
+//
+// It generates repo_metrics.go with:
+//   - RepoMethods: names of Repo methods for database.MetricsConfig.Methods,
+//   - RepoWithMetrics: Repo implementation wrapping given Repo.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("dalgen: ")
+
+	typeName := flag.String("type", "", "repository interface name (required)")
+	output := flag.String("output", "", "output file name (default <type>_metrics.go)")
+	dir := flag.String("dir", ".", "directory with package")
+	flag.Parse()
+
+	if *typeName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = strings.ToLower(*typeName) + "_metrics.go"
+	}
+
+	src, err := generate(*dir, *typeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(*dir, *output), src, 0o644) //nolint:gosec // Generated source code.
+	if err != nil {
+		log.Fatal(fmt.Errorf("os.WriteFile: %w", err))
+	}
+}
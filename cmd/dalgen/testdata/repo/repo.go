@@ -0,0 +1,37 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type User struct {
+	ID      int
+	Created time.Time
+}
+
+type Repo interface {
+	// Get returns user.
+	Get(ctx context.Context, id int) (*User, error)
+	List(ctx context.Context, ids ...int) ([]User, int, error)
+	Del(context.Context, int) error
+	Rename(ctx context.Context, w, err string) error
+	Move(_ context.Context, p0 int, r0 bool) error
+	Tx(ctx context.Context, opts *sql.TxOptions, f func(*sqlx.Tx) error) error
+	Count(ctx context.Context) int
+	Reset()
+}
+
+type Other interface {
+	io.Closer
+}
+
+type NotInterface struct{}
+
+type Generic[T any] interface {
+	Get() T
+}
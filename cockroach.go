@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/sipki-tech/database/internal"
+)
+
+// TxPriority is a CockroachDB transaction priority.
+type TxPriority uint8
+
+// Enum.
+const (
+	_                TxPriority = iota
+	TxPriorityLow               // LOW
+	TxPriorityNormal            // NORMAL
+	TxPriorityHigh              // HIGH
+)
+
+// QoS is a CockroachDB admission control quality of service.
+type QoS uint8
+
+// Enum.
+const (
+	_             QoS = iota
+	QoSBackground     // background
+	QoSRegular        // regular
+	QoSCritical       // critical
+)
+
+// SessionInit returns statement which sets QoS as default for session,
+// use it in SQLConfig.SessionInit.
+func (q QoS) SessionInit() string {
+	return fmt.Sprintf("SET default_transaction_quality_of_service = %s", q)
+}
+
+// CockroachTxOptions for SQL.CockroachTx.
+type CockroachTxOptions struct {
+	// FollowerRead makes read only transaction which reads data as of
+	// follower_read_timestamp(), so it can be served by nearest replica.
+	FollowerRead bool
+	// ExactStaleness makes read only transaction which reads data as of
+	// exactly given time ago. It's ignored if FollowerRead is set.
+	// CockroachDB supports bounded staleness (with_max_staleness) only for
+	// single statements outside of explicit transactions.
+	ExactStaleness time.Duration
+	// Priority of transaction, by default NORMAL.
+	Priority TxPriority
+	// QoS of transaction, by default session's
+	// default_transaction_quality_of_service (see QoS.SessionInit).
+	QoS QoS
+}
+
+// statements returns statements which must be executed at beginning of
+// transaction.
+func (o CockroachTxOptions) statements() []string {
+	var stmts []string
+	switch {
+	case o.FollowerRead:
+		stmts = append(stmts, "SET TRANSACTION AS OF SYSTEM TIME follower_read_timestamp()")
+	case o.ExactStaleness > 0:
+		stmts = append(stmts, fmt.Sprintf("SET TRANSACTION AS OF SYSTEM TIME '-%ss'",
+			strconv.FormatFloat(o.ExactStaleness.Seconds(), 'f', -1, 64)))
+	}
+	if o.Priority != 0 {
+		stmts = append(stmts, fmt.Sprintf("SET TRANSACTION PRIORITY %s", o.Priority))
+	}
+	if o.QoS != 0 {
+		stmts = append(stmts, fmt.Sprintf("SET LOCAL default_transaction_quality_of_service = %s", o.QoS))
+	}
+	return stmts
+}
+
+// CockroachTx is like SQL.Tx, but configures CockroachDB specific
+// transaction options before calling f.
+func (db *SQL) CockroachTx(ctx context.Context, opts CockroachTxOptions, f func(*sqlx.Tx) error) error {
+	txOpts := &sql.TxOptions{ReadOnly: opts.FollowerRead || opts.ExactStaleness > 0}
+	stmts := opts.statements()
+
+	return db.TxNamed(ctx, internal.CallerMethodName(1), txOpts, func(tx *sqlx.Tx) error {
+		for _, stmt := range stmts {
+			_, err := tx.ExecContext(ctx, stmt)
+			if err != nil {
+				return err
+			}
+		}
+		return f(tx)
+	})
+}
@@ -0,0 +1,75 @@
+package database_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+)
+
+func TestSQL_CockroachTx(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		opts      database.CockroachTxOptions
+		wantStmts []string
+	}{
+		"default": {database.CockroachTxOptions{}, nil},
+		"follower_read": {
+			database.CockroachTxOptions{FollowerRead: true, ExactStaleness: time.Second},
+			[]string{"SET TRANSACTION AS OF SYSTEM TIME follower_read_timestamp()"},
+		},
+		"staleness": {
+			database.CockroachTxOptions{ExactStaleness: 1500 * time.Millisecond, Priority: database.TxPriorityHigh},
+			[]string{"SET TRANSACTION AS OF SYSTEM TIME '-1.5s'", "SET TRANSACTION PRIORITY HIGH"},
+		},
+		"priority": {
+			database.CockroachTxOptions{Priority: database.TxPriorityLow},
+			[]string{"SET TRANSACTION PRIORITY LOW"},
+		},
+		"qos": {
+			database.CockroachTxOptions{Priority: database.TxPriorityHigh, QoS: database.QoSCritical},
+			[]string{
+				"SET TRANSACTION PRIORITY HIGH",
+				"SET LOCAL default_transaction_quality_of_service = critical",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+			ctx := context.Background()
+
+			db, mock := start(t, database.SQLConfig{})
+			mock.ExpectBegin()
+			for _, stmt := range tc.wantStmts {
+				mock.ExpectExec(regexp.QuoteMeta(stmt)).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			mock.ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectCommit()
+
+			var ids []int
+			err := db.CockroachTx(ctx, tc.opts, func(tx *sqlx.Tx) error {
+				return tx.SelectContext(ctx, &ids, "select")
+			})
+			r.NoError(err)
+			r.NoError(mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestQoS_SessionInit(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	r.Equal("SET default_transaction_quality_of_service = background", database.QoSBackground.SessionInit())
+}
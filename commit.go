@@ -0,0 +1,56 @@
+package database
+
+import (
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// commitHooks contains funcs registered by OnCommit for transactions
+// started by SQL.Tx and it's derivatives.
+var commitHooks sync.Map // *sqlx.Tx -> *hooks
+
+type hooks struct {
+	mu    sync.Mutex
+	funcs []func()
+}
+
+// OnCommit registers f to be called after tx is successfully committed,
+// f is never called if tx is rolled back. It's useful for side effects
+// which must not happen for rolled back changes, e.g. metrics or
+// notifications. Returns false if tx isn't started by SQL.Tx, in this
+// case f is never called.
+func OnCommit(tx *sqlx.Tx, f func()) bool {
+	v, ok := commitHooks.Load(tx)
+	if !ok {
+		return false
+	}
+
+	h := v.(*hooks)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.funcs = append(h.funcs, f)
+
+	return true
+}
+
+// trackCommit makes OnCommit available for tx, returned func must be
+// called when tx is done.
+func trackCommit(tx *sqlx.Tx) (done func(committed bool)) {
+	h := &hooks{}
+	commitHooks.Store(tx, h)
+
+	return func(committed bool) {
+		commitHooks.Delete(tx)
+		if !committed {
+			return
+		}
+
+		h.mu.Lock()
+		funcs := h.funcs
+		h.mu.Unlock()
+		for _, f := range funcs {
+			f()
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// LSN is a PostgreSQL write-ahead log location.
+type LSN uint64
+
+// ParseLSN parses LSN in PostgreSQL text format (e.g. "16/B374D848").
+func ParseLSN(s string) (LSN, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid LSN: %q", s)
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN: %q: %w", s, err)
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN: %q: %w", s, err)
+	}
+	return LSN(h<<32 | l), nil
+}
+
+// String implements fmt.Stringer.
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint64(l)>>32, uint64(l)&math.MaxUint32)
+}
+
+// lsnUnknown requires reading from primary.
+const lsnUnknown = LSN(math.MaxUint64)
+
+// WriteTracking defines recording of writes for ctx created by WithReadYourWrites.
+type WriteTracking uint8
+
+// Enum.
+const (
+	_ WriteTracking = iota
+	// WriteTrackingLSN queries primary's LSN after every commit, it costs
+	// additional round trip (reported to TxCollector as PhaseLSN).
+	// Following reads use replicas which have replayed it or primary if
+	// LSN is unknown (e.g. query isn't supported by CockroachDB).
+	WriteTrackingLSN // lsn
+	// WriteTrackingPrimary makes all following reads use primary without
+	// additional round trip, e.g. for CockroachDB.
+	WriteTrackingPrimary // primary
+	// WriteTrackingOff doesn't record writes, following reads may use
+	// any replica.
+	WriteTrackingOff // off
+)
+
+// writes contains LSN of last write made with context.
+type writes struct {
+	lsn atomic.Uint64
+}
+
+// observe advances LSN of last write.
+func (w *writes) observe(lsn LSN) {
+	for {
+		cur := w.lsn.Load()
+		if uint64(lsn) <= cur || w.lsn.CompareAndSwap(cur, uint64(lsn)) {
+			return
+		}
+	}
+}
+
+type writesKey struct{}
+
+// WithReadYourWrites returns ctx which provides read-your-writes
+// consistency: SQL.Tx records primary's LSN after commit into ctx (see
+// SQLConfig.WriteTracking) and Router reads with ctx use only replicas
+// which have replayed it.
+// Initial lsn may be 0 or value of ReadYourWritesLSN from previous
+// request (e.g. saved in user session).
+func WithReadYourWrites(ctx context.Context, lsn LSN) context.Context {
+	w := &writes{}
+	w.observe(lsn)
+	return context.WithValue(ctx, writesKey{}, w)
+}
+
+// ReadYourWritesLSN returns LSN of last write made with ctx created by
+// WithReadYourWrites or 0.
+func ReadYourWritesLSN(ctx context.Context) LSN {
+	if w, ok := ctx.Value(writesKey{}).(*writes); ok {
+		return LSN(w.lsn.Load())
+	}
+	return 0
+}
+
+// recordWrite saves write into ctx if it's created by WithReadYourWrites
+// according to SQLConfig.WriteTracking, it isn't called for read only
+// transactions. If LSN is unknown, following
+// reads with ctx use primary.
+func (db *SQL) recordWrite(ctx context.Context, methodName string, conn *sqlx.DB) {
+	w, ok := ctx.Value(writesKey{}).(*writes)
+	if !ok {
+		return
+	}
+
+	switch db.writeTracking {
+	case WriteTrackingOff:
+		return
+	case WriteTrackingPrimary:
+		w.observe(lsnUnknown)
+		return
+	}
+
+	start := time.Now()
+	var s string
+	err := conn.QueryRowContext(ctx, "select pg_current_wal_lsn()::text").Scan(&s)
+	db.txMetrics.TxPhase(methodName, PhaseLSN, time.Since(start))
+	lsn, errParse := ParseLSN(s)
+	if err != nil || errParse != nil {
+		lsn = lsnUnknown
+	}
+	w.observe(lsn)
+}
+
+// replicaStatus returns replayed LSN and replication lag of replica.
+func replicaStatus(ctx context.Context, db *SQL) (LSN, time.Duration, error) {
+	const query = `select coalesce(pg_last_wal_replay_lsn(), '0/0')::text,
+       coalesce(extract(epoch from now() - pg_last_xact_replay_timestamp()), 0)::float8`
+
+	var (
+		s   string
+		lag float64
+	)
+	err := db.withPool(func(p *pool) error {
+		return p.conn.QueryRowContext(ctx, query).Scan(&s, &lag)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	lsn, err := ParseLSN(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lsn, time.Duration(lag * float64(time.Second)), nil
+}
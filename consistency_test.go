@@ -0,0 +1,89 @@
+package database_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+)
+
+func TestParseLSN(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		given   string
+		want    database.LSN
+		wantErr bool
+	}{
+		{"0/0", 0, false},
+		{"0/16B3748", 0x16B3748, false},
+		{"16/B374D848", 0x16B374D848, false},
+		{"FFFFFFFF/FFFFFFFF", 0xFFFFFFFFFFFFFFFF, false},
+		{"", 0, true},
+		{"16B374D848", 0, true},
+		{"1/X", 0, true},
+		{"100000000/0", 0, true},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.given, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			lsn, err := database.ParseLSN(tc.given)
+			if tc.wantErr {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+			r.Equal(tc.want, lsn)
+			r.Equal(tc.given, lsn.String())
+		})
+	}
+}
+
+func TestSQL_WriteTracking(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		tracking     database.WriteTracking
+		wantQuery    bool
+		wantLSN      database.LSN
+		wantObserved float64
+	}{
+		"lsn":     {database.WriteTrackingLSN, true, 0x20, 1},
+		"primary": {database.WriteTrackingPrimary, false, math.MaxUint64, 0},
+		"off":     {database.WriteTrackingOff, false, 0, 0},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			reg := prometheus.NewPedanticRegistry()
+			db, mock := start(t, database.SQLConfig{
+				Metrics:       database.NewMetrics(reg, "test", "db"),
+				WriteTracking: tc.tracking,
+			})
+			mock.ExpectBegin()
+			mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			if tc.wantQuery {
+				mock.ExpectQuery("pg_current_wal_lsn").WillReturnRows(sqlmock.NewRows([]string{"lsn"}).AddRow("0/20"))
+			}
+
+			ctx := database.WithReadYourWrites(context.Background(), 0)
+			r.NoError(repo{db}.TxExec(ctx, "update"))
+			r.Equal(tc.wantLSN, database.ReadYourWritesLSN(ctx))
+			r.Equal(tc.wantObserved, metricValue(t, reg, "test_db_tx_phase_duration_seconds", prometheus.Labels{"phase": "lsn"}))
+			r.NoError(mock.ExpectationsWereMet())
+		})
+	}
+}
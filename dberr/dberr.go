@@ -0,0 +1,229 @@
+// Package dberr maps driver specific errors (lib/pq, pgx, CockroachDB)
+// to driver-agnostic sentinels.
+package dberr
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// Errors.
+var (
+	ErrUniqueViolation           = errors.New("unique violation")
+	ErrForeignKeyViolation       = errors.New("foreign key violation")
+	ErrNotNullViolation          = errors.New("not null violation")
+	ErrCheckViolation            = errors.New("check violation")
+	ErrExclusionViolation        = errors.New("exclusion violation")
+	ErrSerialization             = errors.New("serialization failure")
+	ErrDeadlock                  = errors.New("deadlock detected")
+	ErrLockNotAvailable          = errors.New("lock not available")
+	ErrQueryCanceled             = errors.New("query canceled")
+	ErrReadOnly                  = errors.New("read only transaction")
+	ErrUndefinedTable            = errors.New("undefined table")
+	ErrInsufficientPrivilege     = errors.New("insufficient privilege")
+	ErrConnection                = errors.New("connection failure")
+	ErrCompletionUnknown         = errors.New("statement completion unknown")
+	ErrTooManyConnections        = errors.New("too many connections")
+	ErrInvalidTextRepresentation = errors.New("invalid text representation")
+	ErrStaleStatement            = errors.New("stale prepared statement")
+)
+
+// SQLSTATE codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html.
+var codes = map[string]error{
+	"23505": ErrUniqueViolation,
+	"23503": ErrForeignKeyViolation,
+	"23502": ErrNotNullViolation,
+	"23514": ErrCheckViolation,
+	"23P01": ErrExclusionViolation,
+	"40001": ErrSerialization,
+	"40P01": ErrDeadlock,
+	"40003": ErrCompletionUnknown,
+	"55P03": ErrLockNotAvailable,
+	"57014": ErrQueryCanceled,
+	"25006": ErrReadOnly,
+	"42P01": ErrUndefinedTable,
+	"42501": ErrInsufficientPrivilege,
+	"53300": ErrTooManyConnections,
+	"22P02": ErrInvalidTextRepresentation,
+	"26000": ErrStaleStatement, // invalid_sql_statement_name, e.g. after DISCARD ALL
+	"57P01": ErrConnection,     // admin_shutdown
+	"57P02": ErrConnection,     // crash_shutdown
+	"57P03": ErrConnection,     // cannot_connect_now
+}
+
+// Error contains details of classified database error.
+type Error struct {
+	// Kind is one of package sentinels or nil if code is unknown.
+	Kind error
+	// Code is SQLSTATE, it's empty for connection errors without server response.
+	Code    string
+	Message string
+	// Routine is a name of server's source-code routine reporting error.
+	Routine    string
+	Constraint string
+	Table      string
+	Column     string
+	// Err is an original driver error.
+	Err error
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap allows matching both Kind and original driver error.
+func (e *Error) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Classify returns *Error for driver errors and given err as is
+// for any other errors (including nil and already classified).
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var classified *Error
+	if errors.As(err, &classified) {
+		return err
+	}
+
+	var (
+		pqErr *pq.Error
+		pgErr *pgconn.PgError
+	)
+	switch {
+	case errors.As(err, &pqErr):
+		classified = &Error{
+			Code:       string(pqErr.Code),
+			Message:    pqErr.Message,
+			Routine:    pqErr.Routine,
+			Constraint: pqErr.Constraint,
+			Table:      pqErr.Table,
+			Column:     pqErr.Column,
+		}
+	case errors.As(err, &pgErr):
+		classified = &Error{
+			Code:       pgErr.Code,
+			Message:    pgErr.Message,
+			Routine:    pgErr.Routine,
+			Constraint: pgErr.ConstraintName,
+			Table:      pgErr.TableName,
+			Column:     pgErr.ColumnName,
+		}
+	case isConnection(err):
+		return &Error{Kind: ErrConnection, Message: err.Error(), Err: err}
+	default:
+		return err
+	}
+
+	classified.Err = err
+	classified.Kind = codes[classified.Code]
+	switch {
+	case classified.Kind != nil:
+	case strings.HasPrefix(classified.Code, "08"):
+		classified.Kind = ErrConnection
+	case classified.Code == "0A000" && classified.Routine == "RevalidateCachedQuery":
+		// Prepared statement result type is changed by schema migration
+		// ("cached plan must not change result type").
+		classified.Kind = ErrStaleStatement
+	}
+
+	return classified
+}
+
+// Code returns SQLSTATE of err or empty string.
+func Code(err error) string {
+	var classified *Error
+	if errors.As(Classify(err), &classified) {
+		return classified.Code
+	}
+	return ""
+}
+
+// classes are labels of sentinels returned by Class.
+var classes = map[error]string{
+	ErrUniqueViolation:           "unique_violation",
+	ErrForeignKeyViolation:       "foreign_key_violation",
+	ErrNotNullViolation:          "not_null_violation",
+	ErrCheckViolation:            "check_violation",
+	ErrExclusionViolation:        "exclusion_violation",
+	ErrSerialization:             "serialization_failure",
+	ErrDeadlock:                  "deadlock_detected",
+	ErrLockNotAvailable:          "lock_not_available",
+	ErrQueryCanceled:             "query_canceled",
+	ErrReadOnly:                  "read_only_transaction",
+	ErrUndefinedTable:            "undefined_table",
+	ErrInsufficientPrivilege:     "insufficient_privilege",
+	ErrConnection:                "connection_failure",
+	ErrCompletionUnknown:         "statement_completion_unknown",
+	ErrTooManyConnections:        "too_many_connections",
+	ErrInvalidTextRepresentation: "invalid_text_representation",
+	ErrStaleStatement:            "stale_prepared_statement",
+}
+
+// Class returns short name of error kind suitable for metric labels,
+// e.g. "unique_violation", "connection_failure", "no_rows" or "other".
+func Class(err error) string {
+	err = Classify(err)
+
+	var classified *Error
+	switch {
+	case errors.As(err, &classified) && classes[classified.Kind] != "":
+		return classes[classified.Kind]
+	case errors.Is(err, sql.ErrNoRows):
+		return "no_rows"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+
+	return "other"
+}
+
+// IsRetryable returns true for errors which may disappear after
+// retrying whole transaction.
+func IsRetryable(err error) bool {
+	err = Classify(err)
+	return errors.Is(err, ErrSerialization) || errors.Is(err, ErrDeadlock) || errors.Is(err, ErrConnection)
+}
+
+// isConnection returns true for connection errors of driver or network,
+// EOF returned by anything else (e.g. reading of file) isn't connection error.
+func isConnection(err error) bool {
+	var (
+		netErr     net.Error
+		connectErr *pgconn.ConnectError
+		pgconnErr  interface{ SafeToRetry() bool } // Any error of pgconn.
+	)
+	switch {
+	case errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, net.ErrClosed),
+		errors.As(err, &connectErr):
+		return true
+	case errors.As(err, &netErr):
+		return !netErr.Timeout()
+	case errors.As(err, &pgconnErr):
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// Connection is closed while reading response.
+			return true
+		}
+		// Connection is closed or busy before sending request.
+		return pgconnErr.SafeToRetry() && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	return false
+}
@@ -0,0 +1,129 @@
+package dberr_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database/dberr"
+)
+
+// pgconnErr is like errors of pgconn which wrap network errors.
+type pgconnErr struct{ err error }
+
+func (e *pgconnErr) Error() string     { return "pgconn: " + e.err.Error() }
+func (e *pgconnErr) Unwrap() error     { return e.err }
+func (e *pgconnErr) SafeToRetry() bool { return false }
+
+func TestClassify(t *testing.T) {
+	t.Parallel()
+
+	pqUnique := &pq.Error{Code: "23505", Message: "duplicate key", Constraint: "users_email_key", Table: "users", Column: "email"}
+	pgxFK := &pgconn.PgError{Code: "23503", Message: "fk", ConstraintName: "sessions_user_id_fkey", TableName: "sessions", ColumnName: "user_id"}
+	cockroachRetry := &pgconn.PgError{Code: "40001", Message: "restart transaction"}
+	adminShutdown := &pq.Error{Code: "57P01"}
+	connClass := &pgconn.PgError{Code: "08006"}
+	unknown := &pq.Error{Code: "XX000"}
+	stalePlan := &pgconn.PgError{Code: "0A000", Message: "der gecachte Plan darf den Ergebnistyp nicht ändern", Routine: "RevalidateCachedQuery"}
+	notSupported := &pgconn.PgError{Code: "0A000", Message: "cached plan must not change result type", Routine: "transformStmt"}
+	netEOF := &net.OpError{Op: "read", Net: "tcp", Err: io.EOF}
+	pgconnEOF := &pgconnErr{err: io.ErrUnexpectedEOF}
+
+	testCases := map[string]struct {
+		given     error
+		wantKind  error
+		want      *dberr.Error
+		wantClass bool
+	}{
+		"nil":             {nil, nil, nil, false},
+		"no_rows":         {sql.ErrNoRows, nil, nil, false},
+		"pq_unique":       {fmt.Errorf("wrap: %w", pqUnique), dberr.ErrUniqueViolation, &dberr.Error{Code: "23505", Message: "duplicate key", Constraint: "users_email_key", Table: "users", Column: "email"}, true},
+		"pgx_foreign_key": {pgxFK, dberr.ErrForeignKeyViolation, &dberr.Error{Code: "23503", Message: "fk", Constraint: "sessions_user_id_fkey", Table: "sessions", Column: "user_id"}, true},
+		"cockroach_retry": {cockroachRetry, dberr.ErrSerialization, &dberr.Error{Code: "40001", Message: "restart transaction"}, true},
+		"admin_shutdown":  {adminShutdown, dberr.ErrConnection, &dberr.Error{Code: "57P01"}, true},
+		"connection_code": {connClass, dberr.ErrConnection, &dberr.Error{Code: "08006"}, true},
+		"unknown_code":    {unknown, nil, &dberr.Error{Code: "XX000"}, true},
+		"stale_plan":      {stalePlan, dberr.ErrStaleStatement, &dberr.Error{Code: "0A000", Message: stalePlan.Message, Routine: "RevalidateCachedQuery"}, true},
+		"not_supported":   {notSupported, nil, &dberr.Error{Code: "0A000", Message: notSupported.Message, Routine: "transformStmt"}, true},
+		"bad_conn":        {driver.ErrBadConn, dberr.ErrConnection, &dberr.Error{Message: driver.ErrBadConn.Error()}, true},
+		"net_eof":         {netEOF, dberr.ErrConnection, &dberr.Error{Message: netEOF.Error()}, true},
+		"pgconn_eof":      {pgconnEOF, dberr.ErrConnection, &dberr.Error{Message: pgconnEOF.Error()}, true},
+		"eof":             {io.EOF, nil, nil, false},
+		"wrapped_eof":     {fmt.Errorf("read file: %w", io.ErrUnexpectedEOF), nil, nil, false},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			err := dberr.Classify(tc.given)
+			r.ErrorIs(err, tc.given)
+			if tc.wantKind != nil {
+				r.ErrorIs(err, tc.wantKind)
+			}
+
+			classified := &dberr.Error{}
+			r.Equal(tc.wantClass, errors.As(err, &classified))
+			if !tc.wantClass {
+				r.Equal(tc.given, err)
+				return
+			}
+
+			r.Equal(tc.given.Error(), err.Error())
+			r.Equal(tc.wantKind, classified.Kind)
+			tc.want.Kind = tc.wantKind
+			tc.want.Err = tc.given
+			r.Equal(tc.want, classified)
+			r.Equal(err, dberr.Classify(err))
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	r.True(dberr.IsRetryable(&pgconn.PgError{Code: "40001"}))
+	r.True(dberr.IsRetryable(&pq.Error{Code: "40P01"}))
+	r.False(dberr.IsRetryable(&pq.Error{Code: "23505"}))
+	r.False(dberr.IsRetryable(sql.ErrNoRows))
+}
+
+func TestClass(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		given error
+		want  string
+	}{
+		"unique":     {&pq.Error{Code: "23505"}, "unique_violation"},
+		"connection": {driver.ErrBadConn, "connection_failure"},
+		"no_rows":    {sql.ErrNoRows, "no_rows"},
+		"canceled":   {context.Canceled, "canceled"},
+		"timeout":    {context.DeadlineExceeded, "timeout"},
+		"deadlock":   {&pq.Error{Code: "40P01"}, "deadlock_detected"},
+		"unknown":    {&pq.Error{Code: "XX000"}, "other"},
+		"other":      {errors.New("any error"), "other"},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			r.Equal(tc.want, dberr.Class(tc.given))
+		})
+	}
+}
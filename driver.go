@@ -0,0 +1,648 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync/atomic"
+)
+
+// Driver wrapper allows SQL to observe every statement made by DAL methods,
+// e.g. for counting returned and affected rows.
+// All optional driver interfaces are implemented and fall back to
+// database/sql default behavior if wrapped driver doesn't implement them.
+
+var (
+	_ driver.Connector          = (*wrappedConnector)(nil)
+	_ driver.Conn               = (*wrappedConn)(nil)
+	_ driver.ConnBeginTx        = (*wrappedConn)(nil)
+	_ driver.ConnPrepareContext = (*wrappedConn)(nil)
+	_ driver.ExecerContext      = (*wrappedConn)(nil)
+	_ driver.QueryerContext     = (*wrappedConn)(nil)
+	_ driver.Pinger             = (*wrappedConn)(nil)
+	_ driver.SessionResetter    = (*wrappedConn)(nil)
+	_ driver.Validator          = (*wrappedConn)(nil)
+	_ driver.NamedValueChecker  = (*wrappedConn)(nil)
+	_ driver.Tx                 = (*wrappedTx)(nil)
+	_ driver.Stmt               = (*wrappedStmt)(nil)
+	_ driver.StmtExecContext    = (*wrappedStmt)(nil)
+	_ driver.StmtQueryContext   = (*wrappedStmt)(nil)
+	_ driver.NamedValueChecker  = (*wrappedStmt)(nil)
+	_ driver.Rows               = (*wrappedRows)(nil)
+	_ driver.RowsNextResultSet  = (*wrappedRows)(nil)
+
+	_ driver.RowsColumnTypeScanType         = (*wrappedRows)(nil)
+	_ driver.RowsColumnTypeDatabaseTypeName = (*wrappedRows)(nil)
+	_ driver.RowsColumnTypeLength           = (*wrappedRows)(nil)
+	_ driver.RowsColumnTypeNullable         = (*wrappedRows)(nil)
+	_ driver.RowsColumnTypePrecisionScale   = (*wrappedRows)(nil)
+)
+
+// rowsCounter counts rows of one DAL method call.
+type rowsCounter struct {
+	returned atomic.Int64
+	affected atomic.Int64
+}
+
+type rowsCounterKey struct{}
+
+func withRowsCounter(ctx context.Context, counter *rowsCounter) context.Context {
+	return context.WithValue(ctx, rowsCounterKey{}, counter)
+}
+
+// dsnConnector is used for drivers which don't implement driver.DriverContext.
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+// Connect implements driver.Connector.
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.driver.Open(c.dsn) }
+
+// Driver implements driver.Connector.
+func (c dsnConnector) Driver() driver.Driver { return c.driver }
+
+// Session is a new connection passed to SQLConfig.OnConnect.
+type Session interface {
+	// Exec executes statement on connection.
+	Exec(ctx context.Context, query string, args ...interface{}) error
+}
+
+// connConfig configures wrapped connections.
+type connConfig struct {
+	// onConnect is called for every new connection.
+	onConnect     func(context.Context, Session) error
+	prepared      PreparedStatements
+	stmtCacheSize int
+	// leaks is nil if leaks aren't detected.
+	leaks *leakTracker
+}
+
+// openDB opens database with wrapped driver.
+func openDB(driverName, dsn string, cfg connConfig) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sql.Open: %w", err)
+	}
+	drv := db.Driver()
+	err = db.Close()
+	if err != nil {
+		return nil, fmt.Errorf("db.Close: %w", err)
+	}
+
+	var connector driver.Connector = dsnConnector{dsn: dsn, driver: drv}
+	if driverCtx, ok := drv.(driver.DriverContext); ok {
+		connector, err = driverCtx.OpenConnector(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("driver.OpenConnector: %w", err)
+		}
+	}
+
+	return sql.OpenDB(&wrappedConnector{Connector: connector, cfg: cfg}), nil
+}
+
+type wrappedConnector struct {
+	driver.Connector
+	cfg connConfig
+}
+
+// Connect implements driver.Connector.
+func (c *wrappedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, execer := conn.(driver.ExecerContext)
+	_, queryer := conn.(driver.QueryerContext)
+	if c.cfg.prepared == PreparedDisabled && !(execer && queryer) {
+		_ = conn.Close()
+		return nil, ErrPreparedUnsupported
+	}
+
+	wrapped := &wrappedConn{Conn: conn, prepared: c.cfg.prepared, leaks: c.cfg.leaks}
+	err = c.cfg.onConnect(withDeadlineTimeouts(ctx, nil), wrapped)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// Session init statements aren't cached, they are executed once.
+	if c.cfg.prepared == PreparedCache {
+		wrapped.stmts = newStmtCache(c.cfg.stmtCacheSize)
+	}
+	return wrapped, nil
+}
+
+type wrappedConn struct {
+	driver.Conn
+	// counter is set while connection is used by transaction or SQL.Conn.
+	counter atomic.Pointer[rowsCounter]
+	// savedTimeouts are session timeouts replaced by setDeadlineTimeouts.
+	savedTimeouts []string
+	prepared      PreparedStatements
+	// stmts is nil if prepared statements aren't cached.
+	stmts *stmtCache
+	inTx  bool
+	leaks *leakTracker
+}
+
+// Unwrap returns connection of driver.
+func (c *wrappedConn) Unwrap() driver.Conn {
+	return c.Conn
+}
+
+// rowsCounter returns counter of DAL method call which made statement.
+func (c *wrappedConn) rowsCounter(ctx context.Context) *rowsCounter {
+	if counter, ok := ctx.Value(rowsCounterKey{}).(*rowsCounter); ok {
+		return counter
+	}
+	return c.counter.Load()
+}
+
+// Exec implements Session.
+func (c *wrappedConn) Exec(ctx context.Context, query string, args ...interface{}) error {
+	namedArgs, err := c.namedValues(args)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.ExecContext(ctx, query, namedArgs)
+	if !errors.Is(err, driver.ErrSkip) {
+		return err
+	}
+
+	stmt, err := c.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close() //nolint:errcheck // Nothing to do with error.
+
+	_, err = stmt.(*wrappedStmt).ExecContext(ctx, namedArgs)
+	return err
+}
+
+// queryRow executes query made by wrapper itself and returns its first row.
+// Query isn't observed like statements of DAL methods.
+func (c *wrappedConn) queryRow(ctx context.Context, query string, args ...interface{}) ([]driver.Value, error) {
+	namedArgs, err := c.namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows driver.Rows
+	if queryer, ok := c.Conn.(driver.QueryerContext); ok {
+		rows, err = queryer.QueryContext(ctx, query, namedArgs)
+	} else {
+		err = driver.ErrSkip
+	}
+	if errors.Is(err, driver.ErrSkip) {
+		var stmt driver.Stmt
+		stmt, err = c.PrepareContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		defer stmt.Close() //nolint:errcheck // Nothing to do with error.
+
+		rows, err = queryStmt(ctx, stmt.(*wrappedStmt).Stmt, namedArgs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint:errcheck // Nothing to do with error.
+
+	row := make([]driver.Value, len(rows.Columns()))
+	err = rows.Next(row)
+	if err != nil {
+		return nil, err
+	}
+	for i := range row {
+		// Driver may reuse buffer after rows are closed.
+		if b, ok := row[i].([]byte); ok {
+			row[i] = append([]byte(nil), b...)
+		}
+	}
+	return row, nil
+}
+
+// namedValues converts args like database/sql does.
+func (c *wrappedConn) namedValues(args []interface{}) ([]driver.NamedValue, error) {
+	namedArgs := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		namedArgs[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+		err := c.CheckNamedValue(&namedArgs[i])
+		if errors.Is(err, driver.ErrSkip) {
+			namedArgs[i].Value, err = driver.DefaultParameterConverter.ConvertValue(arg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("convert argument %d: %w", i+1, err)
+		}
+	}
+	return namedArgs, nil
+}
+
+// Prepare implements driver.Conn.
+func (c *wrappedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext implements driver.ConnPrepareContext.
+func (c *wrappedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if c.prepared == PreparedDisabled {
+		return &wrappedStmt{Stmt: &unpreparedStmt{conn: c, query: query}, conn: c, tracked: c.leaks.track(LeakStmt)}, nil
+	}
+
+	stmt, err := c.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wrappedStmt{Stmt: stmt, conn: c, tracked: c.leaks.track(LeakStmt)}, nil
+}
+
+// prepare prepares statement on wrapped connection.
+func (c *wrappedConn) prepare(ctx context.Context, query string) (driver.Stmt, error) {
+	if connCtx, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return connCtx.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+// Begin implements driver.Conn.
+func (c *wrappedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx implements driver.ConnBeginTx.
+func (c *wrappedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (tx driver.Tx, err error) {
+	if connCtx, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = connCtx.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin() //nolint:staticcheck // Fallback for old drivers.
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.setDeadlineTimeouts(ctx, true)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	c.inTx = true
+
+	if counter, ok := ctx.Value(rowsCounterKey{}).(*rowsCounter); ok {
+		c.counter.Store(counter)
+	}
+	return &wrappedTx{Tx: tx, conn: c, tracked: c.leaks.track(LeakTx)}, nil
+}
+
+// ExecContext implements driver.ExecerContext.
+func (c *wrappedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	err := c.setDeadlineTimeouts(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.exec(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	countAffected(c.rowsCounter(ctx), res)
+	return res, nil
+}
+
+func (c *wrappedConn) exec(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.stmts != nil {
+		return c.execCached(ctx, query, args)
+	}
+
+	switch conn := c.Conn.(type) {
+	case driver.ExecerContext:
+		return conn.ExecContext(ctx, query, args)
+	case driver.Execer: //nolint:staticcheck // Fallback for old drivers.
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return conn.Exec(query, values)
+	default:
+		return nil, driver.ErrSkip
+	}
+}
+
+// QueryContext implements driver.QueryerContext.
+func (c *wrappedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	err := c.setDeadlineTimeouts(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wrappedRows{Rows: rows, counter: c.rowsCounter(ctx), conn: c, tracked: c.leaks.track(LeakRows)}, nil
+}
+
+func (c *wrappedConn) query(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.stmts != nil {
+		return c.queryCached(ctx, query, args)
+	}
+
+	switch conn := c.Conn.(type) {
+	case driver.QueryerContext:
+		return conn.QueryContext(ctx, query, args)
+	case driver.Queryer: //nolint:staticcheck // Fallback for old drivers.
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return conn.Query(query, values)
+	default:
+		return nil, driver.ErrSkip
+	}
+}
+
+// Ping implements driver.Pinger.
+func (c *wrappedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// ResetSession implements driver.SessionResetter.
+func (c *wrappedConn) ResetSession(ctx context.Context) error {
+	c.counter.Store(nil)
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		err := resetter.ResetSession(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := c.restoreTimeouts(ctx)
+	if err != nil {
+		// Connection with unknown timeouts must not be reused.
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// IsValid implements driver.Validator.
+func (c *wrappedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue implements driver.NamedValueChecker.
+func (c *wrappedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type wrappedTx struct {
+	driver.Tx
+	conn    *wrappedConn
+	tracked *tracked
+}
+
+// Commit implements driver.Tx.
+func (tx *wrappedTx) Commit() error {
+	tx.conn.counter.Store(nil)
+	tx.conn.inTx = false
+	tx.conn.leaks.untrack(tx.tracked)
+	return tx.Tx.Commit()
+}
+
+// Rollback implements driver.Tx.
+func (tx *wrappedTx) Rollback() error {
+	tx.conn.counter.Store(nil)
+	tx.conn.inTx = false
+	tx.conn.leaks.untrack(tx.tracked)
+	return tx.Tx.Rollback()
+}
+
+type wrappedStmt struct {
+	driver.Stmt
+	conn    *wrappedConn
+	tracked *tracked
+}
+
+// Close implements driver.Stmt.
+func (s *wrappedStmt) Close() error {
+	s.conn.leaks.untrack(s.tracked)
+	return s.Stmt.Close()
+}
+
+// Exec implements driver.Stmt.
+func (s *wrappedStmt) Exec(args []driver.Value) (driver.Result, error) { //nolint:staticcheck // Required by driver.Stmt.
+	res, err := s.Stmt.Exec(args) //nolint:staticcheck // Required by driver.Stmt.
+	if err != nil {
+		return nil, err
+	}
+
+	countAffected(s.conn.counter.Load(), res)
+	return res, nil
+}
+
+// Query implements driver.Stmt.
+func (s *wrappedStmt) Query(args []driver.Value) (driver.Rows, error) { //nolint:staticcheck // Required by driver.Stmt.
+	rows, err := s.Stmt.Query(args) //nolint:staticcheck // Required by driver.Stmt.
+	if err != nil {
+		return nil, err
+	}
+
+	return &wrappedRows{Rows: rows, counter: s.conn.counter.Load(), conn: s.conn, tracked: s.conn.leaks.track(LeakRows)}, nil
+}
+
+// ExecContext implements driver.StmtExecContext.
+func (s *wrappedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (res driver.Result, err error) {
+	err = s.conn.setDeadlineTimeouts(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err = execStmt(ctx, s.Stmt, args)
+	if err != nil {
+		return nil, err
+	}
+
+	countAffected(s.conn.rowsCounter(ctx), res)
+	return res, nil
+}
+
+// QueryContext implements driver.StmtQueryContext.
+func (s *wrappedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+	err = s.conn.setDeadlineTimeouts(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = queryStmt(ctx, s.Stmt, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wrappedRows{Rows: rows, counter: s.conn.rowsCounter(ctx), conn: s.conn, tracked: s.conn.leaks.track(LeakRows)}, nil
+}
+
+// CheckNamedValue implements driver.NamedValueChecker.
+func (s *wrappedStmt) CheckNamedValue(nv *driver.NamedValue) (err error) {
+	switch stmt := s.Stmt.(type) {
+	case driver.NamedValueChecker:
+		return stmt.CheckNamedValue(nv)
+	case driver.ColumnConverter: //nolint:staticcheck // Fallback for old drivers.
+		if nv.Ordinal < 1 || nv.Ordinal > s.Stmt.NumInput() {
+			return driver.ErrSkip
+		}
+		nv.Value, err = stmt.ColumnConverter(nv.Ordinal - 1).ConvertValue(nv.Value)
+		return err
+	}
+
+	return s.conn.CheckNamedValue(nv)
+}
+
+type wrappedRows struct {
+	driver.Rows
+	counter *rowsCounter
+	conn    *wrappedConn
+	tracked *tracked
+}
+
+// Close implements driver.Rows.
+func (r *wrappedRows) Close() error {
+	r.conn.leaks.untrack(r.tracked)
+	return r.Rows.Close()
+}
+
+// Next implements driver.Rows.
+func (r *wrappedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err == nil && r.counter != nil {
+		r.counter.returned.Add(1)
+	}
+	return err
+}
+
+// HasNextResultSet implements driver.RowsNextResultSet.
+func (r *wrappedRows) HasNextResultSet() bool {
+	if rows, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rows.HasNextResultSet()
+	}
+	return false
+}
+
+// NextResultSet implements driver.RowsNextResultSet.
+func (r *wrappedRows) NextResultSet() error {
+	if rows, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rows.NextResultSet()
+	}
+	return io.EOF
+}
+
+// ColumnTypeScanType implements driver.RowsColumnTypeScanType.
+func (r *wrappedRows) ColumnTypeScanType(index int) reflect.Type {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return rows.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+// ColumnTypeDatabaseTypeName implements driver.RowsColumnTypeDatabaseTypeName.
+func (r *wrappedRows) ColumnTypeDatabaseTypeName(index int) string {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return rows.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+// ColumnTypeLength implements driver.RowsColumnTypeLength.
+func (r *wrappedRows) ColumnTypeLength(index int) (length int64, ok bool) {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return rows.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+// ColumnTypeNullable implements driver.RowsColumnTypeNullable.
+func (r *wrappedRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return rows.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+// ColumnTypePrecisionScale implements driver.RowsColumnTypePrecisionScale.
+func (r *wrappedRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if rows, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return rows.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+func countAffected(counter *rowsCounter, res driver.Result) {
+	if counter == nil {
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err == nil {
+		counter.affected.Add(affected)
+	}
+}
+
+func execStmt(ctx context.Context, stmt driver.Stmt, args []driver.NamedValue) (driver.Result, error) {
+	if stmtCtx, ok := stmt.(driver.StmtExecContext); ok {
+		return stmtCtx.ExecContext(ctx, args)
+	}
+
+	values, err := namedValuesToValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Exec(values) //nolint:staticcheck // Fallback for old drivers.
+}
+
+func queryStmt(ctx context.Context, stmt driver.Stmt, args []driver.NamedValue) (driver.Rows, error) {
+	if stmtCtx, ok := stmt.(driver.StmtQueryContext); ok {
+		return stmtCtx.QueryContext(ctx, args)
+	}
+
+	values, err := namedValuesToValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Query(values) //nolint:staticcheck // Fallback for old drivers.
+}
+
+func valuesToNamedValues(values []driver.Value) []driver.NamedValue {
+	args := make([]driver.NamedValue, len(values))
+	for i, value := range values {
+		args[i] = driver.NamedValue{Ordinal: i + 1, Value: value}
+	}
+	return args
+}
+
+func namedValuesToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, driver.ErrSkip
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
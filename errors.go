@@ -0,0 +1,111 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/sipki-tech/database/dberr"
+)
+
+// Phase of DAL method where error happened.
+type Phase uint8
+
+// Enum.
+const (
+	_             Phase = iota
+	PhaseBegin          // begin
+	PhaseExec           // exec
+	PhaseCommit         // commit
+	PhaseRollback       // rollback
+	// PhaseAdmit is before call is started, e.g. call is shed.
+	PhaseAdmit // admit
+	// PhaseLSN is recording of primary's LSN after commit, it's reported
+	// only to TxCollector (see WriteTracking).
+	PhaseLSN // lsn
+)
+
+// PanicPolicy defines handling of panics inside DAL methods.
+type PanicPolicy uint8
+
+// Enum.
+const (
+	_            PanicPolicy = iota
+	PanicRepanic             // repanic
+	PanicToError             // error
+	PanicReport              // report
+)
+
+// PanicError contains recovered panic, it's returned as DALError.Err
+// if SQLConfig.PanicPolicy is PanicToError.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error implements error.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns panic value if it's an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+var _ slog.LogValuer = (*DALError)(nil)
+
+// DALError is returned by SQL methods and describes failed DAL call.
+type DALError struct {
+	Method   string
+	Phase    Phase
+	Duration time.Duration
+	// Err is a primary error, driver errors are classified (see dberr package).
+	Err error
+	// RollbackErr is an error of rollback made after primary error.
+	RollbackErr error
+}
+
+func newDALError(method string, phase Phase, start time.Time, err, errRollback error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &DALError{
+		Method:      method,
+		Phase:       phase,
+		Duration:    time.Since(start),
+		Err:         dberr.Classify(err),
+		RollbackErr: dberr.Classify(errRollback),
+	}
+}
+
+// Error implements error.
+func (e *DALError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("%s: %s: %s", e.Method, e.Err, e.RollbackErr)
+	}
+	return fmt.Sprintf("%s: %s", e.Method, e.Err)
+}
+
+// Unwrap returns primary and rollback errors joined via errors.Join.
+func (e *DALError) Unwrap() error {
+	return errors.Join(e.Err, e.RollbackErr)
+}
+
+// LogValue implements slog.LogValuer.
+func (e *DALError) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("method", e.Method),
+		slog.String("phase", e.Phase.String()),
+		slog.Duration("duration", e.Duration),
+		slog.String("error", e.Err.Error()),
+	}
+	if e.RollbackErr != nil {
+		attrs = append(attrs, slog.String("rollback_error", e.RollbackErr.Error()))
+	}
+
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,3 @@
+package database
+
+//go:generate stringer -type=Phase,PanicPolicy,TxOutcome,TxPriority,QoS,PreparedStatements,LeakKind,PoolDecision,WriteTracking -linecomment
@@ -6,85 +6,122 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 )
 
-// MethodsOf require pointer to interface (e.g.: new(YourInterface)) and
-// returns all it methods.
-func MethodsOf(v interface{}) []string {
-	typ := reflect.TypeOf(v)
-	if typ.Kind() != reflect.Ptr || typ.Elem().Kind() != reflect.Interface {
-		panic("require pointer to interface")
-	}
-	typ = typ.Elem()
-	methods := make([]string, typ.NumMethod())
-	for i := 0; i < typ.NumMethod(); i++ {
-		methods[i] = typ.Method(i).Name
+// MethodsOf require pointers to interfaces (e.g.: new(YourInterface)) or
+// structs (e.g.: (*YourRepo)(nil)) and returns all their exported methods
+// without duplicates. Nil values are ignored.
+func MethodsOf(vs ...interface{}) []string {
+	var methods []string
+	seen := make(map[string]bool)
+	for _, v := range vs {
+		if v == nil {
+			continue
+		}
+		typ := reflect.TypeOf(v)
+		if typ.Kind() != reflect.Ptr {
+			panic("require pointer to interface or struct")
+		}
+		switch typ.Elem().Kind() {
+		case reflect.Interface:
+			typ = typ.Elem()
+		case reflect.Struct:
+		default:
+			panic("require pointer to interface or struct")
+		}
+		for i := 0; i < typ.NumMethod(); i++ {
+			name := typ.Method(i).Name
+			if !seen[name] {
+				seen[name] = true
+				methods = append(methods, name)
+			}
+		}
 	}
 	return methods
 }
 
+// callerNames caches method names by program counter.
+var callerNames sync.Map
+
 // CallerMethodName returns caller's method name for given stack depth.
+// For functions (including generic and closures inside functions) it
+// returns function name.
 func CallerMethodName(skip int) string {
-	return methodName(callerName(1 + skip))
-}
+	var pcs [1]uintptr
+	runtime.Callers(2+skip, pcs[:])
+	if name, ok := callerNames.Load(pcs[0]); ok {
+		return name.(string)
+	}
 
-// Returns:
-//   [example.com/path/]{dir|"main"}.{func|type.method}[."func"id[.id]...]
-func callerName(skip int) string {
-	pc, _, _, _ := runtime.Caller(1 + skip)
-	return runtime.FuncForPC(pc).Name()
+	frame, _ := runtime.CallersFrames(pcs[:]).Next()
+	name := methodName(frame.Function)
+	callerNames.Store(pcs[0], name)
+	return name
 }
 
-// Returns type.method or func."func"id if it's not a method.
-func typeMethodName(name string) string {
-	start := strings.LastIndexByte(name, '/') + 1
-	pos := strings.IndexByte(name[start:], '.')
-	if pos == -1 {
-		panic(fmt.Sprintf("bad name: %s", name))
-	}
-	start += pos + 1
-	pos = strings.IndexByte(name[start:], '.')
-	if pos == -1 {
-		panic(fmt.Sprintf("not a method name: %s", name))
-	}
-	end := strings.IndexByte(name[start+pos+1:], '.')
-	if end == -1 {
-		end = len(name)
-	} else {
-		end += start + pos + 1
+// Removes type arguments of generic types and functions, e.g.
+// Repo[...] becomes Repo.
+func stripTypeArgs(name string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range name {
+		switch {
+		case r == '[':
+			depth++
+		case r == ']' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
 	}
-	return name[start:end]
+	return b.String()
 }
 
-func stripTypeRef(name string) string {
-	if name[0] == '(' {
-		pos := strings.IndexByte(name, ')')
-		name = name[2:pos] + name[pos+1:]
+// Returns true for names of closures, go and defer wrappers.
+func isClosure(name string) bool {
+	for _, prefix := range []string{"func", "gowrap", "deferwrap"} {
+		id := strings.TrimPrefix(name, prefix)
+		if id != name && id != "" && strings.Trim(id, "0123456789") == "" {
+			return true
+		}
 	}
-	return name
+	return false
 }
 
-// Returns method or "func"id if it's not a method.
+// Returns method or func if it's not a method.
+//
+//	[example.com/path/]{dir|"main"}.{func|type.method}[."func"id[.id]...]
 func methodName(name string) string {
-	name = typeMethodName(name)
-	pos := strings.IndexByte(name, '.')
-	if pos == -1 {
-		panic(fmt.Sprintf("not a method name: %s", name))
-	}
-	return name[pos+1:]
-}
-
-// Returns func or method if it's not a function.
-func funcName(name string) string {
 	start := strings.LastIndexByte(name, '/') + 1
 	pos := strings.IndexByte(name[start:], '.')
 	if pos == -1 {
 		panic(fmt.Sprintf("bad name: %s", name))
 	}
-	start += pos + 1
-	pos = strings.IndexByte(name[start:], '.')
-	if pos == -1 {
-		return name[start:]
+	parts := strings.Split(stripTypeArgs(name[start+pos+1:]), ".")
+	switch {
+	case parts[0] == "":
+		panic(fmt.Sprintf("bad name: %s", name))
+	case len(parts) == 1:
+		return parts[0]
+	case parts[0][0] == '(':
+		return parts[1]
+	case isClosure(parts[1]):
+		return parts[0]
+	}
+	return parts[1]
+}
+
+// Backoff returns exponential delay for given attempt (starting from 0),
+// limited by maxDelay.
+func Backoff(minDelay, maxDelay time.Duration, attempt int) time.Duration {
+	delay := minDelay
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
 	}
-	return methodName(name)
+	return delay
 }
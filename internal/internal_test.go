@@ -2,50 +2,50 @@
 package internal
 
 import (
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
-func TestTypeMethodName(t *testing.T) {
+type repo struct{}
+
+func (repo) Get()         {}
+func (*repo) Set()        {}
+func (*repo) unexported() {}
+
+type getDeleter interface {
+	Get()
+	Del()
+}
+
+func TestMethodsOf(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
-		given     string
-		want      string
+		given     []interface{}
+		want      []string
 		wantPanic bool
 	}{
-		{"", "", true},
-		{"bad", "", true},
-		{"main.main", "", true},
-		{"main.f", "", true},
-		{"main.f.func1", "f.func1", false},
-		{"main.f.func2", "f.func2", false},
-		{"main.f.func2.1", "f.func2", false},
-		{"main.f.func2.1.1", "f.func2", false},
-		{"main.f.func3", "f.func3", false},
-		{"main.T.m", "T.m", false},
-		{"main.T.m.func1", "T.m", false},
-		{"main.T.m.func2", "T.m", false},
-		{"main.T.m.func2.1", "T.m", false},
-		{"github.com/powerman/whoami/subpkg.F", "", true},
-		{"github.com/powerman/whoami/subpkg.F.func1", "F.func1", false},
-		{"github.com/powerman/whoami/subpkg.F.func2", "F.func2", false},
-		{"github.com/powerman/whoami/subpkg.F.func2.1", "F.func2", false},
-		{"github.com/powerman/whoami/subpkg.(*T).M", "T.M", false},
-		{"github.com/powerman/whoami/subpkg.(*T).M.func1", "T.M", false},
-		{"github.com/powerman/whoami/subpkg.(*T).M.func2", "T.M", false},
-		{"github.com/powerman/whoami/subpkg.(*T).M.func2.1", "T.M", false},
+		{nil, nil, false},
+		{[]interface{}{nil}, nil, false},
+		{[]interface{}{new(interface{ Get() })}, []string{"Get"}, false},
+		{[]interface{}{new(interface{ Get() }), new(getDeleter)}, []string{"Get", "Del"}, false},
+		{[]interface{}{(*repo)(nil), new(interface{ Del() })}, []string{"Get", "Set", "Del"}, false},
+		{[]interface{}{repo{}}, nil, true},
+		{[]interface{}{new(int)}, nil, true},
 	}
-	for _, tc := range tests {
+	for i, tc := range tests {
 		tc := tc
-		t.Run(tc.given, func(tt *testing.T) {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Parallel()
 			r := require.New(t)
 
 			if tc.wantPanic {
-				r.Panics(func() { typeMethodName(tc.given) })
+				r.Panics(func() { MethodsOf(tc.given...) })
 			} else {
-				r.Equal(stripTypeRef(typeMethodName(tc.given)), tc.want)
+				r.Equal(tc.want, MethodsOf(tc.given...))
 			}
 		})
 	}
@@ -61,25 +61,33 @@ func TestMethodName(t *testing.T) {
 	}{
 		{"", "", true},
 		{"bad", "", true},
-		{"main.main", "", true},
-		{"main.f", "", true},
-		{"main.f.func1", "func1", false},
-		{"main.f.func2", "func2", false},
-		{"main.f.func2.1", "func2", false},
-		{"main.f.func2.1.1", "func2", false},
-		{"main.f.func3", "func3", false},
+		{"main.main", "main", false},
+		{"main.f", "f", false},
+		{"main.f.func1", "f", false},
+		{"main.f.func2", "f", false},
+		{"main.f.func2.1", "f", false},
+		{"main.f.func2.1.1", "f", false},
+		{"main.f.gowrap1", "f", false},
+		{"main.f.deferwrap1", "f", false},
 		{"main.T.m", "m", false},
 		{"main.T.m.func1", "m", false},
 		{"main.T.m.func2", "m", false},
 		{"main.T.m.func2.1", "m", false},
-		{"github.com/powerman/whoami/subpkg.F", "", true},
-		{"github.com/powerman/whoami/subpkg.F.func1", "func1", false},
-		{"github.com/powerman/whoami/subpkg.F.func2", "func2", false},
-		{"github.com/powerman/whoami/subpkg.F.func2.1", "func2", false},
+		{"main.T.function", "function", false},
+		{"github.com/powerman/whoami/subpkg.F", "F", false},
+		{"github.com/powerman/whoami/subpkg.F.func1", "F", false},
+		{"github.com/powerman/whoami/subpkg.F.func2", "F", false},
+		{"github.com/powerman/whoami/subpkg.F.func2.1", "F", false},
 		{"github.com/powerman/whoami/subpkg.(*T).M", "M", false},
 		{"github.com/powerman/whoami/subpkg.(*T).M.func1", "M", false},
 		{"github.com/powerman/whoami/subpkg.(*T).M.func2", "M", false},
 		{"github.com/powerman/whoami/subpkg.(*T).M.func2.1", "M", false},
+		{"github.com/powerman/whoami/subpkg.F[...]", "F", false},
+		{"github.com/powerman/whoami/subpkg.F[...].func1", "F", false},
+		{"github.com/powerman/whoami/subpkg.T[...].M", "M", false},
+		{"github.com/powerman/whoami/subpkg.(*T[...]).M", "M", false},
+		{"github.com/powerman/whoami/subpkg.(*T[...]).M.func1", "M", false},
+		{"github.com/powerman/whoami/subpkg.(*T[go.shape.int]).M", "M", false},
 	}
 	for _, tc := range tests {
 		tc := tc
@@ -89,53 +97,55 @@ func TestMethodName(t *testing.T) {
 			if tc.wantPanic {
 				r.Panics(func() { methodName(tc.given) })
 			} else {
-				r.Equal(methodName(tc.given), tc.want)
+				r.Equal(tc.want, methodName(tc.given))
 			}
 		})
 	}
 }
 
-func TestFuncName(t *testing.T) {
+type generic[T any] struct{}
+
+func (generic[T]) method() string { return CallerMethodName(0) }
+
+func (g *generic[T]) closure() (name string) {
+	func() { name = CallerMethodName(0) }()
+	return name
+}
+
+func function() string { return CallerMethodName(0) }
+
+func TestCallerMethodName(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	r.Equal("method", generic[int]{}.method())
+	r.Equal("closure", new(generic[string]).closure())
+	r.Equal("function", function())
+	r.Equal("function", function())
+}
+
+func TestBackoff(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
-		given     string
-		want      string
-		wantPanic bool
+		attempt int
+		want    time.Duration
 	}{
-		{"", "", true},
-		{"bad", "", true},
-		{"main.main", "main", false},
-		{"main.f", "f", false},
-		{"main.f.func1", "func1", false},
-		{"main.f.func2", "func2", false},
-		{"main.f.func2.1", "func2", false},
-		{"main.f.func2.1.1", "func2", false},
-		{"main.f.func3", "func3", false},
-		{"main.T.m", "m", false},
-		{"main.T.m.func1", "m", false},
-		{"main.T.m.func2", "m", false},
-		{"main.T.m.func2.1", "m", false},
-		{"github.com/powerman/whoami/subpkg.F", "F", false},
-		{"github.com/powerman/whoami/subpkg.F.func1", "func1", false},
-		{"github.com/powerman/whoami/subpkg.F.func2", "func2", false},
-		{"github.com/powerman/whoami/subpkg.F.func2.1", "func2", false},
-		{"github.com/powerman/whoami/subpkg.(*T).M", "M", false},
-		{"github.com/powerman/whoami/subpkg.(*T).M.func1", "M", false},
-		{"github.com/powerman/whoami/subpkg.(*T).M.func2", "M", false},
-		{"github.com/powerman/whoami/subpkg.(*T).M.func2.1", "M", false},
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second},
+		{100, 10 * time.Second},
 	}
 
 	for _, tc := range tests {
 		tc := tc
-		t.Run(tc.given, func(tt *testing.T) {
+		t.Run(strconv.Itoa(tc.attempt), func(t *testing.T) {
+			t.Parallel()
 			r := require.New(t)
 
-			if tc.wantPanic {
-				r.Panics(func() { funcName(tc.given) })
-			} else {
-				r.Equal(funcName(tc.given), tc.want)
-			}
+			r.Equal(tc.want, Backoff(time.Second, 10*time.Second, tc.attempt))
 		})
 	}
 }
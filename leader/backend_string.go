@@ -0,0 +1,25 @@
+// Code generated by "stringer -type=Backend -linecomment"; DO NOT EDIT.
+
+package leader
+
+import "strconv"
+
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[PostgresAdvisoryLock-1]
+	_ = x[CockroachLease-2]
+}
+
+const _Backend_name = "postgres_advisory_lockcockroach_lease"
+
+var _Backend_index = [...]uint8{0, 22, 37}
+
+func (i Backend) String() string {
+	idx := int(i) - 1
+	if i < 1 || idx >= len(_Backend_index)-1 {
+		return "Backend(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _Backend_name[_Backend_index[idx]:_Backend_index[idx+1]]
+}
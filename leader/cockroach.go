@@ -0,0 +1,103 @@
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func (e *Elector) createLeaseTable(ctx context.Context) error {
+	query := fmt.Sprintf(`create table if not exists %s
+(
+    name       text        not null,
+    holder     text        not null,
+    expires_at timestamptz not null,
+    primary key (name)
+);`, e.cfg.LeaseTableName)
+
+	return e.db.NoTxNamed(methodPrefix+"createLeaseTable", func(db *sqlx.DB) error {
+		_, err := db.ExecContext(ctx, query)
+		return err
+	})
+}
+
+// lease tries to take lease and renews it until lease is lost or ctx is done.
+func (e *Elector) lease(ctx context.Context) (err error) {
+	deadline, ok, err := e.renew(ctx)
+	if err != nil || !ok {
+		return err
+	}
+	defer func() {
+		e.setLeader(false)
+		err = e.release(err)
+	}()
+
+	e.setLeader(true)
+	for {
+		err = sleep(ctx, e.cfg.RetryInterval)
+		if err != nil {
+			return nil //nolint:nilerr // Context done is normal shutdown.
+		}
+
+		var nextDeadline time.Time
+		nextDeadline, ok, err = e.renew(ctx)
+		switch {
+		case err != nil && time.Now().Before(deadline.Add(-e.cfg.RetryInterval)):
+			// Lease is still valid, try to renew it next time.
+			e.cfg.OnError(err)
+		case err != nil:
+			return err
+		case !ok:
+			return nil
+		default:
+			deadline = nextDeadline
+		}
+	}
+}
+
+// renew takes or extends lease and returns its local deadline.
+func (e *Elector) renew(ctx context.Context) (deadline time.Time, ok bool, err error) {
+	query := fmt.Sprintf(`insert into %[1]s (name, holder, expires_at)
+values ($1, $2, now() + $3 * interval '1 millisecond')
+on conflict (name) do update set holder = excluded.holder, expires_at = excluded.expires_at
+where %[1]s.holder = excluded.holder or %[1]s.expires_at < now()
+returning holder`, e.cfg.LeaseTableName)
+
+	start := time.Now()
+	err = e.db.NoTxNamed(methodPrefix+"renew", func(db *sqlx.DB) error {
+		holder := ""
+		err := db.GetContext(ctx, &holder, query, e.cfg.Name, e.cfg.ID, e.cfg.LeaseTTL.Milliseconds())
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil
+		case err != nil:
+			return err
+		}
+
+		ok = true
+		return nil
+	})
+
+	return start.Add(e.cfg.LeaseTTL), ok, err
+}
+
+// release removes own lease, so other instances don't wait for its expiration.
+func (e *Elector) release(err error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.RetryInterval)
+	defer cancel()
+
+	query := fmt.Sprintf(`delete from %s where name = $1 and holder = $2`, e.cfg.LeaseTableName)
+	errRelease := e.db.NoTxNamed(methodPrefix+"release", func(db *sqlx.DB) error {
+		_, err := db.ExecContext(ctx, query, e.cfg.Name, e.cfg.ID)
+		return err
+	})
+	if err == nil {
+		err = errRelease
+	}
+
+	return err
+}
@@ -0,0 +1,3 @@
+package leader
+
+//go:generate stringer -type=Backend -linecomment
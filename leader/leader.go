@@ -0,0 +1,221 @@
+// Package leader contains leader election built on top of database.
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/internal"
+)
+
+// Backend is a way for holding leadership.
+type Backend uint8
+
+// Enum.
+const (
+	_ Backend = iota
+	// PostgresAdvisoryLock holds session level advisory lock on dedicated
+	// connection. Leadership is lost together with connection.
+	// Connection is taken from pool of database.SQL for whole leadership,
+	// so it's one connection less for other calls (see
+	// database.SQLConfig.SetMaxOpenConnections), and duration of
+	// "leader.advisoryLock" call is a duration of leadership.
+	PostgresAdvisoryLock // postgres_advisory_lock
+	// CockroachLease holds row in lease table and renews it with heartbeats.
+	// Leadership is lost when lease isn't renewed in time.
+	CockroachLease // cockroach_lease
+)
+
+// methodPrefix is a prefix of method names used for calls of database.SQL,
+// so they aren't mixed with DAL methods in metrics.
+const methodPrefix = "leader."
+
+// ErrNoName is returned by Elector.Run if Config.Name is empty.
+var ErrNoName = errors.New("empty election name")
+
+// Default values for config.
+const (
+	DefaultLeaseTTL       = time.Second * 15
+	DefaultRetryInterval  = time.Second * 5
+	DefaultLeaseTableName = "leader_lease"
+)
+
+// Config for set additional properties.
+type Config struct {
+	// Name of election, instances with same name compete for leadership.
+	// It's required.
+	Name string
+	// ID of this instance, by default it's hostname and pid.
+	ID      string
+	Backend Backend
+	// LeaseTTL is a lease duration for CockroachLease.
+	LeaseTTL time.Duration
+	// RetryInterval is an interval between attempts to get leadership
+	// and between heartbeats of current leader.
+	RetryInterval time.Duration
+	// LeaseTableName is a table used by CockroachLease, it's created if not exists.
+	LeaseTableName string
+	// Metrics receives leadership state if it implements database.LeaderCollector.
+	Metrics database.MetricCollector
+	// OnChange is called on every leadership change.
+	OnChange func(isLeader bool)
+	// OnError is called for every database error.
+	OnError func(error)
+}
+
+func (c Config) setDefault() Config {
+	if c.ID == "" {
+		hostname, _ := os.Hostname()
+		c.ID = hostname + "-" + strconv.Itoa(os.Getpid())
+	}
+	if c.Backend == 0 {
+		c.Backend = PostgresAdvisoryLock
+	}
+	if c.LeaseTTL == 0 {
+		c.LeaseTTL = DefaultLeaseTTL
+	}
+	if c.RetryInterval == 0 {
+		c.RetryInterval = DefaultRetryInterval
+	}
+	if c.LeaseTableName == "" {
+		c.LeaseTableName = DefaultLeaseTableName
+	}
+	if c.Metrics == nil {
+		c.Metrics = database.NoMetric{}
+	}
+	if c.OnChange == nil {
+		c.OnChange = func(bool) {}
+	}
+	if c.OnError == nil {
+		c.OnError = func(error) {}
+	}
+	return c
+}
+
+// Elector takes part in leader election.
+type Elector struct {
+	db  *database.SQL
+	cfg Config
+
+	mu         sync.Mutex
+	leader     bool
+	termCtx    context.Context
+	termCancel context.CancelFunc
+}
+
+// New build and returns new Elector.
+// Elector doesn't take part in election until Run is called.
+func New(db *database.SQL, cfg Config) *Elector {
+	termCtx, termCancel := context.WithCancel(context.Background())
+	termCancel()
+
+	return &Elector{
+		db:         db,
+		cfg:        cfg.setDefault(),
+		termCtx:    termCtx,
+		termCancel: termCancel,
+	}
+}
+
+// IsLeader returns true if this instance is a leader.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.leader
+}
+
+// Context returns context which is cancelled on loss of leadership.
+// If this instance isn't a leader returned context is already cancelled.
+func (e *Elector) Context() context.Context {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.termCtx
+}
+
+// Run takes part in election until ctx is done.
+// Leadership is released before return.
+func (e *Elector) Run(ctx context.Context) error {
+	if e.cfg.Name == "" {
+		return ErrNoName
+	}
+
+	var campaign func(context.Context) error
+	switch e.cfg.Backend {
+	case PostgresAdvisoryLock:
+		campaign = e.advisoryLock
+	case CockroachLease:
+		err := e.createLeaseTable(ctx)
+		if err != nil {
+			return fmt.Errorf("e.createLeaseTable: %w", err)
+		}
+		campaign = e.lease
+	default:
+		return fmt.Errorf("unknown backend: %d", e.cfg.Backend)
+	}
+
+	e.setLeader(false)
+	for attempt := 0; ; attempt++ {
+		err := campaign(ctx)
+		e.setLeader(false)
+		if ctx.Err() != nil {
+			return nil //nolint:nilerr // Context done is normal shutdown.
+		}
+		if err != nil {
+			e.cfg.OnError(err)
+		} else {
+			attempt = 0
+		}
+
+		err = sleep(ctx, internal.Backoff(e.cfg.RetryInterval, e.cfg.LeaseTTL, attempt))
+		if err != nil {
+			return nil //nolint:nilerr // Context done is normal shutdown.
+		}
+	}
+}
+
+func (e *Elector) setLeader(isLeader bool) {
+	e.mu.Lock()
+	changed := e.leader != isLeader
+	e.leader = isLeader
+	if isLeader && changed {
+		e.termCtx, e.termCancel = context.WithCancel(context.Background())
+	} else if !isLeader {
+		e.termCancel()
+	}
+	e.mu.Unlock()
+
+	if collector, ok := e.cfg.Metrics.(database.LeaderCollector); ok {
+		collector.Leader(e.cfg.Name, isLeader)
+	}
+	if changed {
+		e.cfg.OnChange(isLeader)
+	}
+}
+
+// lockKey returns advisory lock key for election name.
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64()) //nolint:gosec // Overflow is expected.
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,176 @@
+package leader_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/connectors"
+	"github.com/sipki-tech/database/leader"
+)
+
+func TestElector_CockroachLease(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	dsn := t.Name() + time.Now().String()
+	_, mock, err := sqlmock.NewWithDSN(dsn)
+	r.NoError(err)
+
+	mock.MatchExpectationsInOrder(true)
+	mock.ExpectExec(`create table if not exists leader_lease`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`insert into leader_lease`).
+		WithArgs("job", "instance", int64(1000)).
+		WillReturnRows(sqlmock.NewRows([]string{"holder"}).AddRow("instance"))
+	mock.ExpectQuery(`insert into leader_lease`).
+		WithArgs("job", "instance", int64(1000)).
+		WillReturnRows(sqlmock.NewRows([]string{"holder"}))
+	mock.ExpectExec(`delete from leader_lease`).
+		WithArgs("job", "instance").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectClose()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	reg := prometheus.NewPedanticRegistry()
+	db, err := database.NewSQL(ctx, "sqlmock", database.SQLConfig{
+		Metrics: database.NewMetrics(reg, "test", "db"),
+	}, &connectors.Raw{Query: dsn})
+	r.NoError(err)
+
+	changes := make(chan bool, 2)
+	e := leader.New(db, leader.Config{
+		Name:          "job",
+		ID:            "instance",
+		Backend:       leader.CockroachLease,
+		LeaseTTL:      time.Second,
+		RetryInterval: time.Millisecond * 50,
+		OnChange:      func(isLeader bool) { changes <- isLeader },
+	})
+	r.False(e.IsLeader())
+	r.Error(e.Context().Err())
+
+	done := make(chan error)
+	go func() { done <- e.Run(ctx) }()
+
+	r.True(<-changes)
+	r.False(<-changes)
+	r.False(e.IsLeader())
+	r.Error(e.Context().Err())
+
+	cancel()
+	r.NoError(<-done)
+	r.NoError(db.Close())
+	r.NoError(mock.ExpectationsWereMet())
+
+	methods := make(map[string]bool)
+	families, err := reg.Gather()
+	r.NoError(err)
+	for _, family := range families {
+		if family.GetName() != "test_db_call_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "func" {
+					methods[label.GetValue()] = true
+				}
+			}
+		}
+	}
+	r.Equal(map[string]bool{"leader.createLeaseTable": true, "leader.renew": true, "leader.release": true}, methods)
+}
+
+func TestElector_PostgresAdvisoryLock(t *testing.T) {
+	t.Parallel()
+
+	errAny := errors.New("any error")
+
+	testCases := map[string]struct {
+		lose   bool
+		expect func(sqlmock.Sqlmock)
+	}{
+		"release": {false, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`select pg_try_advisory_lock\(\$1\)`).
+				WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+			mock.ExpectExec(`select pg_advisory_unlock\(\$1\)`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+		}},
+		"lose": {true, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`select pg_try_advisory_lock\(\$1\)`).
+				WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+			mock.ExpectPing().WillReturnError(errAny)
+			mock.ExpectExec(`select pg_advisory_unlock\(\$1\)`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+		}},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			dsn := t.Name() + time.Now().String()
+			_, mock, err := sqlmock.NewWithDSN(dsn, sqlmock.MonitorPingsOption(tc.lose))
+			r.NoError(err)
+			mock.MatchExpectationsInOrder(true)
+			if tc.lose {
+				mock.ExpectPing() // NewSQL.
+			}
+			tc.expect(mock)
+			mock.ExpectClose()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+
+			db, err := database.NewSQL(ctx, "sqlmock", database.SQLConfig{}, &connectors.Raw{Query: dsn})
+			r.NoError(err)
+
+			changes := make(chan bool, 2)
+			errs := make(chan error, 1)
+			e := leader.New(db, leader.Config{
+				Name:          "job",
+				RetryInterval: time.Millisecond * 50,
+				OnChange:      func(isLeader bool) { changes <- isLeader },
+				OnError:       func(err error) { errs <- err },
+			})
+
+			done := make(chan error)
+			go func() { done <- e.Run(ctx) }()
+
+			r.True(<-changes)
+			r.True(e.IsLeader())
+			r.NoError(e.Context().Err())
+			if tc.lose {
+				r.False(<-changes)
+				r.ErrorIs(<-errs, errAny)
+			}
+			cancel()
+			r.NoError(<-done)
+			if !tc.lose {
+				r.False(<-changes)
+			}
+			r.False(e.IsLeader())
+			r.Error(e.Context().Err())
+
+			r.NoError(db.Close())
+			r.NoError(mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestElector_NoName(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	e := leader.New(nil, leader.Config{})
+	r.ErrorIs(e.Run(context.Background()), leader.ErrNoName)
+}
@@ -0,0 +1,66 @@
+package leader
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// advisoryLock tries to take advisory lock and holds it while
+// connection is alive and ctx isn't done.
+func (e *Elector) advisoryLock(ctx context.Context) error {
+	return e.db.ConnNamed(ctx, methodPrefix+"advisoryLock", func(conn *sqlx.Conn) (err error) {
+		key := lockKey(e.cfg.Name)
+
+		locked := false
+		err = conn.GetContext(ctx, &locked, "select pg_try_advisory_lock($1)", key)
+		if err != nil || !locked {
+			return err
+		}
+		defer func() {
+			e.setLeader(false)
+			err = unlock(conn, key, err)
+		}()
+
+		e.setLeader(true)
+		for {
+			err = sleep(ctx, e.cfg.RetryInterval)
+			if err != nil {
+				return nil //nolint:nilerr // Context done is normal shutdown.
+			}
+
+			err = ping(ctx, conn, e.cfg.RetryInterval)
+			if err != nil {
+				return fmt.Errorf("ping: %w", err)
+			}
+		}
+	})
+}
+
+func ping(ctx context.Context, conn *sqlx.Conn, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return conn.PingContext(ctx)
+}
+
+// unlock releases advisory lock. If it isn't possible, connection is
+// closed for sure, because returning it to pool with lock is a deadlock.
+func unlock(conn *sqlx.Conn, key int64, err error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, errUnlock := conn.ExecContext(ctx, "select pg_advisory_unlock($1)", key)
+	if errUnlock == nil {
+		return err
+	}
+
+	_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	if err == nil {
+		err = fmt.Errorf("conn.ExecContext: %w", errUnlock)
+	}
+	return err
+}
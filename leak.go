@@ -0,0 +1,129 @@
+package database
+
+import (
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLeakTimeout is a default value of SQLConfig.LeakTimeout.
+const DefaultLeakTimeout = time.Minute
+
+// LeakKind is a kind of resource tracked by leak detector.
+type LeakKind uint8
+
+// Enum.
+const (
+	_        LeakKind = iota
+	LeakRows          // rows
+	LeakStmt          // statement
+	LeakTx            // transaction
+)
+
+// Leak is a rows, statement or transaction which isn't closed.
+type Leak struct {
+	Kind    LeakKind
+	Created time.Time
+	// Stack is a stack trace of goroutine which created resource.
+	Stack []byte
+}
+
+// leakTracker tracks open resources if SQLConfig.DetectLeaks is set,
+// nil tracker tracks nothing.
+type leakTracker struct {
+	timeout time.Duration
+	onLeak  func(Leak)
+
+	mu   sync.Mutex
+	open map[*tracked]struct{}
+}
+
+type tracked struct {
+	leak  Leak
+	timer *time.Timer
+}
+
+func newLeakTracker(timeout time.Duration, onLeak func(Leak)) *leakTracker {
+	return &leakTracker{
+		timeout: timeout,
+		onLeak:  onLeak,
+		open:    make(map[*tracked]struct{}),
+	}
+}
+
+// track starts tracking of new resource, OnLeak is called if it isn't
+// untracked until timeout.
+func (t *leakTracker) track(kind LeakKind) *tracked {
+	if t == nil {
+		return nil
+	}
+
+	tr := &tracked{leak: Leak{Kind: kind, Created: time.Now(), Stack: debug.Stack()}}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.open[tr] = struct{}{}
+	tr.timer = time.AfterFunc(t.timeout, func() { t.onLeak(tr.leak) })
+	return tr
+}
+
+// untrack stops tracking of closed resource, it may be called many times.
+func (t *leakTracker) untrack(tr *tracked) {
+	if t == nil || tr == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tr.timer.Stop()
+	delete(t.open, tr)
+}
+
+// leaks returns open resources, oldest first.
+func (t *leakTracker) leaks() []Leak {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	leaks := make([]Leak, 0, len(t.open))
+	for tr := range t.open {
+		leaks = append(leaks, tr.leak)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(leaks, func(i, j int) bool { return leaks[i].Created.Before(leaks[j].Created) })
+	return leaks
+}
+
+// Leaks returns rows, statements and transactions which aren't closed yet,
+// oldest first. It returns nil if SQLConfig.DetectLeaks isn't set.
+func (db *SQL) Leaks() []Leak {
+	return db.leaks.leaks()
+}
+
+// TestingT is a subset of testing.TB used by CloseNoLeaks.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// CloseNoLeaks is a test helper which closes db and fails test for every
+// rows, statement or transaction which isn't closed yet. Leaks are
+// collected before closing, because closing connection closes statements.
+// It requires SQLConfig.DetectLeaks.
+func CloseNoLeaks(t TestingT, db *SQL) {
+	t.Helper()
+
+	if db.leaks == nil {
+		t.Errorf("SQLConfig.DetectLeaks isn't set")
+	}
+	for _, leak := range db.Leaks() {
+		t.Errorf("%s leaked, created %s ago at:\n%s", leak.Kind, time.Since(leak.Created), leak.Stack)
+	}
+
+	err := db.Close()
+	if err != nil {
+		t.Errorf("close: %s", err)
+	}
+}
@@ -0,0 +1,91 @@
+package database_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+)
+
+// recorder is a database.TestingT which records errors.
+type recorder struct{ errs []string }
+
+func (*recorder) Helper() {}
+
+func (rec *recorder) Errorf(format string, args ...interface{}) {
+	rec.errs = append(rec.errs, fmt.Sprintf(format, args...))
+}
+
+func TestSQL_Leaks(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	leaks := make(chan database.Leak, 1)
+	db, mock := start(t, database.SQLConfig{
+		DetectLeaks: true,
+		LeakTimeout: time.Millisecond * 10,
+		OnLeak:      func(leak database.Leak) { leaks <- leak },
+	})
+	mock.ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+
+	var rows *sqlx.Rows
+	err := db.NoTxContext(ctx, func(ctx context.Context, db *sqlx.DB) (err error) {
+		rows, err = db.QueryxContext(ctx, "select")
+		return err
+	})
+	r.NoError(err)
+
+	leak := <-leaks
+	r.Equal(database.LeakRows, leak.Kind)
+	r.Contains(string(leak.Stack), "TestSQL_Leaks")
+	r.Len(db.Leaks(), 1)
+
+	r.NoError(rows.Close())
+	r.Empty(db.Leaks())
+
+	mock.ExpectClose()
+	rec := &recorder{}
+	database.CloseNoLeaks(rec, db)
+	r.Empty(rec.errs)
+	r.NoError(mock.ExpectationsWereMet())
+}
+
+func TestCloseNoLeaks(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	db, mock := start(t, database.SQLConfig{DetectLeaks: true})
+	mock.ExpectBegin()
+	mock.ExpectPrepare("update")
+
+	err := db.NoTxContext(ctx, func(ctx context.Context, db *sqlx.DB) error {
+		tx, err := db.BeginTxx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		_, err = tx.PreparexContext(ctx, "update")
+		return err
+	})
+	r.NoError(err)
+	r.NoError(mock.ExpectationsWereMet())
+
+	rec := &recorder{}
+	database.CloseNoLeaks(rec, db)
+	r.Len(rec.errs, 2)
+	r.Contains(rec.errs[0], "transaction leaked")
+	r.Contains(rec.errs[1], "statement leaked")
+
+	rec = &recorder{}
+	db, mock = start(t, database.SQLConfig{})
+	mock.ExpectClose()
+	database.CloseNoLeaks(rec, db)
+	r.Equal([]string{"SQLConfig.DetectLeaks isn't set"}, rec.errs)
+}
@@ -1,10 +1,14 @@
 package database
 
 import (
+	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/sipki-tech/database/dberr"
 	"github.com/sipki-tech/database/internal"
 )
 
@@ -14,64 +18,437 @@ type MetricCollector interface {
 	Collecting(method string, f func() error) func() error
 }
 
+// LeaderCollector is an optional MetricCollector extension for
+// publishing leader election state.
+type LeaderCollector interface {
+	// Leader sets current leadership state for given election.
+	Leader(election string, isLeader bool)
+}
+
+// TxCollector is an optional MetricCollector extension for collecting
+// transaction lifecycle metrics in SQL.Tx.
+type TxCollector interface {
+	// TxPhase observes duration of transaction phase.
+	TxPhase(method string, phase Phase, duration time.Duration)
+	// TxOutcome counts finished transactions.
+	TxOutcome(method string, outcome TxOutcome)
+}
+
+// RowsCollector is an optional MetricCollector extension for collecting
+// amount of rows returned by queries and affected by execs inside DAL
+// method call. Rows are counted for SQL.Tx, SQL.Conn and SQL.NoTxContext,
+// but not for SQL.NoTx, because its statements have no context of call.
+type RowsCollector interface {
+	// Rows observes amount of rows for DAL method call.
+	Rows(method string, returned, affected int64)
+}
+
+// ConnCollector is an optional MetricCollector extension for collecting
+// results of new connections initialization (see SQLConfig.SessionInit).
+type ConnCollector interface {
+	// ConnInit observes result of connection initialization.
+	ConnInit(err error)
+}
+
+// ReplicaCollector is an optional MetricCollector extension for
+// collecting replication lag measured by Router.
+type ReplicaCollector interface {
+	// ReplicaLag sets current replication lag of replica.
+	ReplicaLag(replica string, lag time.Duration)
+}
+
+// PoolCollector is an optional MetricCollector extension for collecting
+// decisions of adaptive pool controller (see SQLConfig.Adaptive).
+type PoolCollector interface {
+	// PoolResized observes change of pool size.
+	PoolResized(size int, decision PoolDecision)
+	// Shed counts DAL method calls rejected because pool is overloaded.
+	Shed(method string)
+}
+
+// TxOutcome is a result of transaction.
+type TxOutcome uint8
+
+// Enum.
+const (
+	_                TxOutcome = iota
+	TxCommitted                // committed
+	TxRolledBack               // rolled_back
+	TxRollbackFailed           // rollback_failed
+	TxPanicked                 // panicked
+)
+
 const (
-	labelFunc = "func" // Value: caller's func/method name.
+	labelFunc     = "func"     // Value: caller's func/method name.
+	labelElection = "election" // Value: leader election name.
+	labelPhase    = "phase"    // Value: Phase.
+	labelOutcome  = "outcome"  // Value: TxOutcome.
+	labelClass    = "class"    // Value: dberr.Class of error.
+	labelReplica  = "replica"  // Value: index of Router replica.
+	labelShard    = "shard"    // Value: index of ShardedSQL shard.
+	labelTenant   = "tenant"   // Value: tenant of TenantPools pool.
+	labelDecision = "decision" // Value: PoolDecision.
+)
+
+var (
+	_ MetricCollector  = Metrics{}
+	_ LeaderCollector  = Metrics{}
+	_ TxCollector      = Metrics{}
+	_ RowsCollector    = Metrics{}
+	_ ConnCollector    = Metrics{}
+	_ ReplicaCollector = Metrics{}
+	_ PoolCollector    = Metrics{}
 )
 
-var _ MetricCollector = Metrics{}
+// MetricsConfig for set additional properties of Metrics.
+type MetricsConfig struct {
+	// Registerer for metrics, by default prometheus.DefaultRegisterer.
+	// Already registered metrics are reused, so many SQL instances may
+	// share same Registerer.
+	Registerer prometheus.Registerer
+	Namespace  string
+	Subsystem  string
+	// MethodsFrom are pointers to interfaces or structs, their exported
+	// methods are used for labels pre-initialization.
+	// More methods may be added later by Metrics.AddMethods.
+	MethodsFrom []interface{}
+	// Methods are names of methods for labels pre-initialization, e.g.
+	// generated by cmd/dalgen.
+	Methods []string
+	// Buckets for histograms, by default prometheus.DefBuckets.
+	Buckets []float64
+	// NativeHistogramBucketFactor enables native histograms if > 1.
+	// If Buckets is empty, classic buckets are disabled.
+	NativeHistogramBucketFactor float64
+	// RowsBuckets for rows_returned and rows_affected histograms, by
+	// default exponential buckets from 1 to 262144.
+	RowsBuckets []float64
+	// ConstLabels are added to every metric (e.g. database name or role).
+	ConstLabels prometheus.Labels
+	// InFlight enables gauge of running DAL calls.
+	InFlight bool
+	// ErrorClass adds label with error class (see dberr.Class) to errors_total.
+	ErrorClass bool
+}
+
+func (c MetricsConfig) setDefault() MetricsConfig {
+	if c.Registerer == nil {
+		c.Registerer = prometheus.DefaultRegisterer
+	}
+	if len(c.Buckets) == 0 && c.NativeHistogramBucketFactor <= 1 {
+		c.Buckets = prometheus.DefBuckets
+	}
+	if len(c.RowsBuckets) == 0 && c.NativeHistogramBucketFactor <= 1 {
+		c.RowsBuckets = prometheus.ExponentialBuckets(1, 4, 10)
+	}
+	return c
+}
+
+// histogramOpts returns options for histogram with configured buckets.
+func (c MetricsConfig) histogramOpts(name, help string) prometheus.HistogramOpts {
+	return prometheus.HistogramOpts{
+		Namespace:                   c.Namespace,
+		Subsystem:                   c.Subsystem,
+		Name:                        name,
+		Help:                        help,
+		ConstLabels:                 c.ConstLabels,
+		Buckets:                     c.Buckets,
+		NativeHistogramBucketFactor: c.NativeHistogramBucketFactor,
+	}
+}
 
 // Metrics contains general metrics for DAL methods.
 type Metrics struct {
-	callErrTotal *prometheus.CounterVec
-	callDuration *prometheus.HistogramVec
+	callErrTotal   *prometheus.CounterVec
+	callPanicTotal *prometheus.CounterVec
+	callDuration   *prometheus.HistogramVec
+	callInFlight   *prometheus.GaugeVec // May be nil.
+	leader         *lazy[*prometheus.GaugeVec]
+	txPhase        *prometheus.HistogramVec
+	txTotal        *prometheus.CounterVec
+	rowsReturned   *prometheus.HistogramVec
+	rowsAffected   *prometheus.HistogramVec
+	connInitTotal  prometheus.Counter
+	connInitErrors *prometheus.CounterVec
+	replicaLag     *lazy[*prometheus.GaugeVec]
+	poolSize       *lazy[prometheus.Gauge]
+	poolResized    *lazy[*prometheus.CounterVec]
+	shedTotal      *lazy[*prometheus.CounterVec]
+	errorClass     bool
 }
 
 // NewMetrics registers and returns common DAL metrics used by all
 // services (namespace).
-func NewMetrics(reg *prometheus.Registry, namespace, subsystem string, methodsFrom interface{}) (metric Metrics) {
-	metric.callErrTotal = prometheus.NewCounterVec(
+// Exported methods of methodsFrom (pointers to interfaces or structs) are
+// used for labels pre-initialization.
+func NewMetrics(reg prometheus.Registerer, namespace, subsystem string, methodsFrom ...interface{}) Metrics {
+	return NewMetricsWithConfig(MetricsConfig{
+		Registerer:  reg,
+		Namespace:   namespace,
+		Subsystem:   subsystem,
+		MethodsFrom: methodsFrom,
+	})
+}
+
+// NewMetricsWithConfig registers and returns common DAL metrics
+// configured by cfg. Metrics of leader election, replicas and adaptive
+// pool are registered on first use.
+func NewMetricsWithConfig(cfg MetricsConfig) (metric Metrics) {
+	cfg = cfg.setDefault()
+	reg := cfg.Registerer
+
+	errLabels := []string{labelFunc}
+	if cfg.ErrorClass {
+		errLabels = append(errLabels, labelClass)
+	}
+	metric.errorClass = cfg.ErrorClass
+	metric.callErrTotal = register(reg, prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Namespace: namespace,
-			Subsystem: subsystem,
-			Name:      "errors_total",
-			Help:      "Amount of DAL errors.",
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        "errors_total",
+			Help:        "Amount of DAL errors.",
+			ConstLabels: cfg.ConstLabels,
 		},
+		errLabels,
+	))
+	metric.callPanicTotal = register(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        "panics_total",
+			Help:        "Amount of DAL panics.",
+			ConstLabels: cfg.ConstLabels,
+		},
+		[]string{labelFunc},
+	))
+	metric.callDuration = register(reg, prometheus.NewHistogramVec(
+		cfg.histogramOpts("call_duration_seconds", "DAL call latency."),
 		[]string{labelFunc},
-	)
-	reg.MustRegister(metric.callErrTotal)
-	metric.callDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Namespace: namespace,
-			Subsystem: subsystem,
-			Name:      "call_duration_seconds",
-			Help:      "DAL call latency.",
+	))
+	if cfg.InFlight {
+		metric.callInFlight = register(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   cfg.Namespace,
+				Subsystem:   cfg.Subsystem,
+				Name:        "in_flight",
+				Help:        "Amount of running DAL calls.",
+				ConstLabels: cfg.ConstLabels,
+			},
+			[]string{labelFunc},
+		))
+	}
+	metric.leader = newLazy(reg, prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        "leader",
+			Help:        "Whether this instance is a leader (1) or not (0).",
+			ConstLabels: cfg.ConstLabels,
+		},
+		[]string{labelElection},
+	))
+	metric.txPhase = register(reg, prometheus.NewHistogramVec(
+		cfg.histogramOpts("tx_phase_duration_seconds", "DAL transaction phase latency."),
+		[]string{labelFunc, labelPhase},
+	))
+	metric.txTotal = register(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        "tx_total",
+			Help:        "Amount of finished DAL transactions.",
+			ConstLabels: cfg.ConstLabels,
+		},
+		[]string{labelFunc, labelOutcome},
+	))
+
+	rowsOpts := cfg.histogramOpts("rows_returned", "Amount of rows returned by queries inside DAL call.")
+	rowsOpts.Buckets = cfg.RowsBuckets
+	metric.rowsReturned = register(reg, prometheus.NewHistogramVec(rowsOpts, []string{labelFunc}))
+	rowsOpts.Name, rowsOpts.Help = "rows_affected", "Amount of rows affected by execs inside DAL call."
+	metric.rowsAffected = register(reg, prometheus.NewHistogramVec(rowsOpts, []string{labelFunc}))
+
+	metric.connInitTotal = register(reg, prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        "conn_init_total",
+			Help:        "Amount of initialized new connections.",
+			ConstLabels: cfg.ConstLabels,
+		},
+	))
+	metric.connInitErrors = register(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        "conn_init_errors_total",
+			Help:        "Amount of new connections discarded because of initialization error.",
+			ConstLabels: cfg.ConstLabels,
+		},
+		[]string{labelClass},
+	))
+
+	metric.replicaLag = newLazy(reg, prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        "replica_lag_seconds",
+			Help:        "Replication lag of replica measured by router.",
+			ConstLabels: cfg.ConstLabels,
+		},
+		[]string{labelReplica},
+	))
+
+	metric.poolSize = newLazy(reg, prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        "pool_max_open_connections",
+			Help:        "Maximum amount of open connections set by adaptive pool controller.",
+			ConstLabels: cfg.ConstLabels,
+		},
+	))
+	metric.poolResized = newLazy(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        "pool_resized_total",
+			Help:        "Amount of pool size changes made by adaptive pool controller.",
+			ConstLabels: cfg.ConstLabels,
+		},
+		[]string{labelDecision},
+	))
+	metric.shedTotal = newLazy(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        "shed_total",
+			Help:        "Amount of DAL calls rejected because pool is overloaded.",
+			ConstLabels: cfg.ConstLabels,
 		},
 		[]string{labelFunc},
-	)
-	reg.MustRegister(metric.callDuration)
+	))
 
-	for _, methodName := range internal.MethodsOf(methodsFrom) {
+	metric.AddMethods(internal.MethodsOf(cfg.MethodsFrom...)...)
+	metric.AddMethods(cfg.Methods...)
+
+	return metric
+}
+
+// AddMethods pre-initializes labels for given method names, it's useful
+// for methods of repositories created after Metrics.
+func (m Metrics) AddMethods(methodNames ...string) {
+	for _, methodName := range methodNames {
 		l := prometheus.Labels{
 			labelFunc: methodName,
 		}
-		metric.callErrTotal.With(l)
-		metric.callDuration.With(l)
+		if !m.errorClass {
+			m.callErrTotal.With(l)
+		}
+		m.callPanicTotal.With(l)
+		m.callDuration.With(l)
+		if m.callInFlight != nil {
+			m.callInFlight.With(l)
+		}
+		for _, outcome := range []TxOutcome{TxCommitted, TxRolledBack, TxRollbackFailed, TxPanicked} {
+			m.txTotal.With(prometheus.Labels{labelFunc: methodName, labelOutcome: outcome.String()})
+		}
 	}
+}
 
-	return metric
+// Unregister unregisters metrics from reg, it's useful for metrics with
+// const labels of database which isn't used anymore.
+func (m Metrics) Unregister(reg prometheus.Registerer) {
+	collectors := []prometheus.Collector{
+		m.callErrTotal, m.callPanicTotal, m.callDuration,
+		m.txPhase, m.txTotal, m.rowsReturned, m.rowsAffected,
+		m.connInitTotal, m.connInitErrors,
+	}
+	if m.callInFlight != nil {
+		collectors = append(collectors, m.callInFlight)
+	}
+	for _, l := range []lazyCollector{
+		m.leader, m.replicaLag, m.poolSize, m.poolResized, m.shedTotal,
+	} {
+		if collector, ok := l.registered(); ok {
+			collectors = append(collectors, collector)
+		}
+	}
+	for _, collector := range collectors {
+		reg.Unregister(collector)
+	}
+}
+
+// register registers collector or returns already registered one.
+func register[T prometheus.Collector](reg prometheus.Registerer, collector T) T {
+	err := reg.Register(collector)
+	errRegistered := prometheus.AlreadyRegisteredError{}
+	if errors.As(err, &errRegistered) {
+		if existing, ok := errRegistered.ExistingCollector.(T); ok {
+			return existing
+		}
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	return collector
+}
+
+// lazy registers collector on first use, so metrics of features which
+// aren't used (leader election, replicas, adaptive pool) aren't exported.
+type lazy[T prometheus.Collector] struct {
+	reg       prometheus.Registerer
+	once      sync.Once
+	collector T
+	done      atomic.Bool // Collector is registered.
+}
+
+func newLazy[T prometheus.Collector](reg prometheus.Registerer, collector T) *lazy[T] {
+	return &lazy[T]{reg: reg, collector: collector}
+}
+
+func (l *lazy[T]) get() T {
+	l.once.Do(func() {
+		l.collector = register(l.reg, l.collector)
+		l.done.Store(true)
+	})
+	return l.collector
+}
+
+// lazyCollector is a lazy of any collector type.
+type lazyCollector interface {
+	registered() (prometheus.Collector, bool)
+}
+
+// registered returns collector if it's registered.
+func (l *lazy[T]) registered() (prometheus.Collector, bool) {
+	if !l.done.Load() {
+		return nil, false
+	}
+	return l.collector, true
 }
 
 // Collecting implements MetricCollector.
+// Panics, including converted to *PanicError, are counted separately from errors.
 func (m Metrics) Collecting(method string, f func() error) func() error {
 	return func() (err error) {
 		start := time.Now()
 		l := prometheus.Labels{labelFunc: method}
+		if m.callInFlight != nil {
+			m.callInFlight.With(l).Inc()
+			defer m.callInFlight.With(l).Dec()
+		}
 		defer func() {
 			m.callDuration.With(l).Observe(time.Since(start).Seconds())
-			if err != nil {
-				m.callErrTotal.With(l).Inc()
+			errPanic := &PanicError{}
+			if errors.As(err, &errPanic) {
+				m.callPanicTotal.With(l).Inc()
+			} else if err != nil {
+				m.callErrTotal.With(m.errLabels(method, err)).Inc()
 			} else if err := recover(); err != nil {
-				m.callErrTotal.With(l).Inc()
+				m.callPanicTotal.With(l).Inc()
 				panic(err)
 			}
 		}()
@@ -79,7 +456,71 @@ func (m Metrics) Collecting(method string, f func() error) func() error {
 	}
 }
 
-var _ MetricCollector = NoMetric{}
+func (m Metrics) errLabels(method string, err error) prometheus.Labels {
+	if !m.errorClass {
+		return prometheus.Labels{labelFunc: method}
+	}
+	return prometheus.Labels{labelFunc: method, labelClass: dberr.Class(err)}
+}
+
+// Leader implements LeaderCollector.
+func (m Metrics) Leader(election string, isLeader bool) {
+	value := 0.0
+	if isLeader {
+		value = 1
+	}
+	m.leader.get().With(prometheus.Labels{labelElection: election}).Set(value)
+}
+
+// TxPhase implements TxCollector.
+func (m Metrics) TxPhase(method string, phase Phase, duration time.Duration) {
+	m.txPhase.With(prometheus.Labels{labelFunc: method, labelPhase: phase.String()}).Observe(duration.Seconds())
+}
+
+// TxOutcome implements TxCollector.
+func (m Metrics) TxOutcome(method string, outcome TxOutcome) {
+	m.txTotal.With(prometheus.Labels{labelFunc: method, labelOutcome: outcome.String()}).Inc()
+}
+
+// Rows implements RowsCollector.
+func (m Metrics) Rows(method string, returned, affected int64) {
+	l := prometheus.Labels{labelFunc: method}
+	m.rowsReturned.With(l).Observe(float64(returned))
+	m.rowsAffected.With(l).Observe(float64(affected))
+}
+
+// ConnInit implements ConnCollector.
+func (m Metrics) ConnInit(err error) {
+	m.connInitTotal.Inc()
+	if err != nil {
+		m.connInitErrors.With(prometheus.Labels{labelClass: dberr.Class(err)}).Inc()
+	}
+}
+
+// ReplicaLag implements ReplicaCollector.
+func (m Metrics) ReplicaLag(replica string, lag time.Duration) {
+	m.replicaLag.get().With(prometheus.Labels{labelReplica: replica}).Set(lag.Seconds())
+}
+
+// PoolResized implements PoolCollector.
+func (m Metrics) PoolResized(size int, decision PoolDecision) {
+	m.poolSize.get().Set(float64(size))
+	m.poolResized.get().With(prometheus.Labels{labelDecision: decision.String()}).Inc()
+}
+
+// Shed implements PoolCollector.
+func (m Metrics) Shed(method string) {
+	m.shedTotal.get().With(prometheus.Labels{labelFunc: method}).Inc()
+}
+
+var (
+	_ MetricCollector  = NoMetric{}
+	_ TxCollector      = NoMetric{}
+	_ RowsCollector    = NoMetric{}
+	_ ConnCollector    = NoMetric{}
+	_ ReplicaCollector = NoMetric{}
+	_ PoolCollector    = NoMetric{}
+)
 
 // NoMetric if you want to turn off metrics.
 type NoMetric struct{}
@@ -88,3 +529,24 @@ type NoMetric struct{}
 func (n NoMetric) Collecting(_ string, f func() error) func() error {
 	return func() error { return f() }
 }
+
+// TxPhase implements TxCollector.
+func (n NoMetric) TxPhase(string, Phase, time.Duration) {}
+
+// TxOutcome implements TxCollector.
+func (n NoMetric) TxOutcome(string, TxOutcome) {}
+
+// Rows implements RowsCollector.
+func (n NoMetric) Rows(string, int64, int64) {}
+
+// ConnInit implements ConnCollector.
+func (n NoMetric) ConnInit(error) {}
+
+// ReplicaLag implements ReplicaCollector.
+func (n NoMetric) ReplicaLag(string, time.Duration) {}
+
+// PoolResized implements PoolCollector.
+func (n NoMetric) PoolResized(int, PoolDecision) {}
+
+// Shed implements PoolCollector.
+func (n NoMetric) Shed(string) {}
@@ -0,0 +1,125 @@
+package database_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+)
+
+func TestNewMetrics_Reuse(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	reg := prometheus.NewPedanticRegistry()
+	first := database.NewMetrics(reg, "test", "db", new(interface{ Get() }))
+	second := database.NewMetrics(reg, "test", "db", new(interface{ Get() }))
+
+	r.NoError(first.Collecting("Get", func() error { return nil })())
+	r.NoError(second.Collecting("Get", func() error { return nil })())
+	r.Equal(2.0, metricValue(t, reg, "test_db_call_duration_seconds", prometheus.Labels{"func": "Get"}))
+}
+
+func TestNewMetricsWithConfig(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	reg := prometheus.NewPedanticRegistry()
+	for _, role := range []string{"primary", "replica"} {
+		metrics := database.NewMetricsWithConfig(database.MetricsConfig{
+			Registerer:  reg,
+			Namespace:   "test",
+			Subsystem:   "db",
+			MethodsFrom: []interface{}{new(interface{ Get() })},
+			Methods:     []string{"Set"},
+			Buckets:     []float64{0.1, 1},
+			ConstLabels: prometheus.Labels{"role": role},
+			InFlight:    true,
+			ErrorClass:  true,
+		})
+
+		err := metrics.Collecting("Get", func() error {
+			return &pq.Error{Code: "23505"}
+		})()
+		r.Error(err)
+		err = metrics.Collecting("Get", func() error {
+			return errors.New("any error")
+		})()
+		r.Error(err)
+	}
+
+	const want = `
+# HELP test_db_errors_total Amount of DAL errors.
+# TYPE test_db_errors_total counter
+test_db_errors_total{class="other",func="Get",role="primary"} 1
+test_db_errors_total{class="other",func="Get",role="replica"} 1
+test_db_errors_total{class="unique_violation",func="Get",role="primary"} 1
+test_db_errors_total{class="unique_violation",func="Get",role="replica"} 1
+# HELP test_db_in_flight Amount of running DAL calls.
+# TYPE test_db_in_flight gauge
+test_db_in_flight{func="Get",role="primary"} 0
+test_db_in_flight{func="Get",role="replica"} 0
+test_db_in_flight{func="Set",role="primary"} 0
+test_db_in_flight{func="Set",role="replica"} 0
+`
+	r.NoError(testutil.GatherAndCompare(reg, strings.NewReader(want), "test_db_errors_total", "test_db_in_flight"))
+}
+
+type getSetter interface {
+	Get()
+	Set()
+}
+
+func TestMetrics_AddMethods(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	reg := prometheus.NewPedanticRegistry()
+	metrics := database.NewMetrics(reg, "test", "db", new(interface{ Get() }), new(getSetter))
+	metrics.AddMethods("Del")
+
+	const want = `
+# HELP test_db_panics_total Amount of DAL panics.
+# TYPE test_db_panics_total counter
+test_db_panics_total{func="Del"} 0
+test_db_panics_total{func="Get"} 0
+test_db_panics_total{func="Set"} 0
+`
+	r.NoError(testutil.GatherAndCompare(reg, strings.NewReader(want), "test_db_panics_total"))
+}
+
+func TestMetrics_LazyFeatures(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	reg := prometheus.NewPedanticRegistry()
+	metrics := database.NewMetrics(reg, "test", "db")
+	names := func() []string {
+		families, err := reg.Gather()
+		r.NoError(err)
+		var names []string
+		for _, family := range families {
+			names = append(names, family.GetName())
+		}
+		return names
+	}
+	r.Equal([]string{"test_db_conn_init_total"}, names())
+
+	metrics.Leader("job", true)
+	metrics.PoolResized(5, database.PoolGrow)
+	r.Equal([]string{
+		"test_db_conn_init_total",
+		"test_db_leader",
+		"test_db_pool_max_open_connections",
+		"test_db_pool_resized_total",
+	}, names())
+
+	metrics.Unregister(reg)
+	r.Empty(names())
+}
@@ -0,0 +1,344 @@
+// Package notify contains helpers for PostgreSQL LISTEN/NOTIFY.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/internal"
+)
+
+// Default values for config.
+const (
+	DefaultMinReconnectInterval = time.Millisecond * 100
+	DefaultMaxReconnectInterval = time.Second * 30
+	DefaultBufferSize           = 64
+)
+
+// Errors.
+var (
+	ErrClosed            = errors.New("listener closed")
+	ErrAlreadySubscribed = errors.New("already subscribed")
+	ErrNotSubscribed     = errors.New("not subscribed")
+)
+
+// Notification contains information about received notification.
+type Notification struct {
+	// PID of the notifying server process.
+	PID     uint32
+	Channel string
+	Payload string
+}
+
+// Handler is a callback for received notifications.
+type Handler func(Notification)
+
+// Config for set additional properties.
+type Config struct {
+	// MinReconnectInterval and MaxReconnectInterval limits exponential
+	// backoff between reconnection attempts.
+	MinReconnectInterval time.Duration
+	MaxReconnectInterval time.Duration
+	// BufferSize of channels returned by Listener.Subscribe.
+	BufferSize int
+	// OnConnect is called after every successful (re)connect, when all
+	// channels are already listened. Notifications sent while listener
+	// was disconnected are lost, so it's a good place for resync.
+	OnConnect func()
+	// OnError is called for every connection error.
+	OnError func(error)
+}
+
+func (c Config) setDefault() Config {
+	if c.MinReconnectInterval == 0 {
+		c.MinReconnectInterval = DefaultMinReconnectInterval
+	}
+	if c.MaxReconnectInterval == 0 {
+		c.MaxReconnectInterval = DefaultMaxReconnectInterval
+	}
+	if c.BufferSize == 0 {
+		c.BufferSize = DefaultBufferSize
+	}
+	if c.OnConnect == nil {
+		c.OnConnect = func() {}
+	}
+	if c.OnError == nil {
+		c.OnError = func(error) {}
+	}
+	return c
+}
+
+// conn is a part of *pgx.Conn used by Listener.
+type conn interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	WaitForNotification(ctx context.Context) (*pgconn.Notification, error)
+	Close(ctx context.Context) error
+}
+
+// Listener holds dedicated connection for receiving notifications.
+// It is not part of SQL pool, because listened channels are bound to session.
+type Listener struct {
+	cfg       Config
+	connector database.Connector
+	connect   func(ctx context.Context, dsn string) (conn, error)
+
+	mu       sync.Mutex
+	handlers map[string]subscription
+	wake     chan struct{}
+	closed   bool
+}
+
+type subscription struct {
+	// handler is called with ctx of Run.
+	handler func(ctx context.Context, n Notification)
+	cleanup func()
+}
+
+// NewListener build and returns new Listener.
+// Listener doesn't connect to database until Run is called.
+func NewListener(cfg Config, connector database.Connector) *Listener {
+	return &Listener{
+		cfg:       cfg.setDefault(),
+		connector: connector,
+		connect: func(ctx context.Context, dsn string) (conn, error) {
+			return pgx.Connect(ctx, dsn)
+		},
+		handlers: make(map[string]subscription),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Handle subscribes to channel and calls f for every notification.
+// Handlers are called sequentially from Run goroutine, so f mustn't block.
+// It's safe to call Subscribe and Unsubscribe inside f.
+func (l *Listener) Handle(channel string, f Handler) error {
+	return l.subscribe(channel, subscription{
+		handler: func(_ context.Context, n Notification) { f(n) },
+		cleanup: func() {},
+	})
+}
+
+// Subscribe subscribes to channel and returns Go channel with notifications.
+// Returned channel is closed after Unsubscribe or when Run returns.
+// Slow reader blocks delivering of other notifications until ctx or
+// ctx of Run is done, notification is dropped in this case.
+func (l *Listener) Subscribe(ctx context.Context, channel string) (<-chan Notification, error) {
+	ch := make(chan Notification, l.cfg.BufferSize)
+	stop := make(chan struct{})
+	// Serializes sending to ch and closing it.
+	var mu sync.Mutex
+
+	err := l.subscribe(channel, subscription{
+		handler: func(runCtx context.Context, n Notification) {
+			mu.Lock()
+			defer mu.Unlock()
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			select {
+			case ch <- n:
+			case <-ctx.Done():
+			case <-runCtx.Done():
+			case <-stop:
+			}
+		},
+		cleanup: func() {
+			close(stop)
+			mu.Lock()
+			defer mu.Unlock()
+			close(ch)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+func (l *Listener) subscribe(channel string, sub subscription) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	if _, ok := l.handlers[channel]; ok {
+		return fmt.Errorf("%w: %s", ErrAlreadySubscribed, channel)
+	}
+
+	l.handlers[channel] = sub
+	l.notify()
+
+	return nil
+}
+
+// Unsubscribe stops listening channel.
+func (l *Listener) Unsubscribe(channel string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sub, ok := l.handlers[channel]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotSubscribed, channel)
+	}
+	delete(l.handlers, channel)
+	sub.cleanup()
+	l.notify()
+
+	return nil
+}
+
+// Run connects to database and delivers notifications until ctx is done.
+// Lost connection is restored with exponential backoff and all channels
+// are listened again.
+// Listener can't be reused after Run returns.
+func (l *Listener) Run(ctx context.Context) error {
+	defer l.close()
+
+	for attempt := 0; ; attempt++ {
+		err := l.session(ctx, func() { attempt = 0 })
+		if ctx.Err() != nil {
+			return nil //nolint:nilerr // Context done is normal shutdown.
+		}
+		l.cfg.OnError(err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(internal.Backoff(l.cfg.MinReconnectInterval, l.cfg.MaxReconnectInterval, attempt)):
+		}
+	}
+}
+
+// session serves one connection until it's broken.
+func (l *Listener) session(ctx context.Context, connected func()) error {
+	dsn, err := l.connector.DSN()
+	if err != nil {
+		return fmt.Errorf("connector.DSN: %w", err)
+	}
+
+	conn, err := l.connect(ctx, dsn)
+	if err != nil {
+		return fmt.Errorf("pgx.Connect: %w", err)
+	}
+	defer conn.Close(context.Background()) //nolint:errcheck // Connection is already broken or unneeded.
+
+	listened := make(map[string]bool)
+	err = l.sync(ctx, conn, listened)
+	if err != nil {
+		return fmt.Errorf("l.sync: %w", err)
+	}
+	connected()
+	l.cfg.OnConnect()
+
+	for {
+		waitCtx, cancel := context.WithCancel(ctx)
+		exited := make(chan struct{})
+		go func() {
+			defer close(exited)
+			select {
+			case <-l.wake:
+				cancel()
+			case <-waitCtx.Done():
+			}
+		}()
+
+		n, err := conn.WaitForNotification(waitCtx)
+		woken := waitCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+		// Wake consumed by goroutine must be followed by sync below.
+		<-exited
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case woken:
+			// Subscriptions were changed.
+		case err != nil:
+			return fmt.Errorf("conn.WaitForNotification: %w", err)
+		}
+
+		if n != nil {
+			l.deliver(ctx, Notification{PID: n.PID, Channel: n.Channel, Payload: n.Payload})
+		}
+
+		err = l.sync(ctx, conn, listened)
+		if err != nil {
+			return fmt.Errorf("l.sync: %w", err)
+		}
+	}
+}
+
+// sync executes LISTEN/UNLISTEN for making listened channels equal to handlers.
+func (l *Listener) sync(ctx context.Context, conn conn, listened map[string]bool) error {
+	l.mu.Lock()
+	var listen, unlisten []string
+	for channel := range l.handlers {
+		if !listened[channel] {
+			listen = append(listen, channel)
+		}
+	}
+	for channel := range listened {
+		if _, ok := l.handlers[channel]; !ok {
+			unlisten = append(unlisten, channel)
+		}
+	}
+	l.mu.Unlock()
+
+	for _, channel := range listen {
+		_, err := conn.Exec(ctx, "listen "+pgx.Identifier{channel}.Sanitize())
+		if err != nil {
+			return fmt.Errorf("conn.Exec: %w", err)
+		}
+		listened[channel] = true
+	}
+
+	for _, channel := range unlisten {
+		_, err := conn.Exec(ctx, "unlisten "+pgx.Identifier{channel}.Sanitize())
+		if err != nil {
+			return fmt.Errorf("conn.Exec: %w", err)
+		}
+		delete(listened, channel)
+	}
+
+	return nil
+}
+
+func (l *Listener) deliver(ctx context.Context, n Notification) {
+	l.mu.Lock()
+	sub, ok := l.handlers[n.Channel]
+	l.mu.Unlock()
+
+	// Handler is called without lock, so it may change subscriptions.
+	if ok {
+		sub.handler(ctx, n)
+	}
+}
+
+// notify wakes up Run for syncing subscriptions. Must be called under lock.
+func (l *Listener) notify() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (l *Listener) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for channel, sub := range l.handlers {
+		delete(l.handlers, channel)
+		sub.cleanup()
+	}
+}
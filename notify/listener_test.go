@@ -0,0 +1,217 @@
+//nolint:testpackage // Testing with fake connection.
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database/connectors"
+)
+
+var (
+	errConnect = errors.New("connect error")
+	errBroken  = errors.New("connection broken")
+)
+
+type fakeConn struct {
+	execs         chan string
+	notifications chan *pgconn.Notification
+	broken        chan struct{}
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		execs:         make(chan string, 10),
+		notifications: make(chan *pgconn.Notification),
+		broken:        make(chan struct{}),
+	}
+}
+
+func (c *fakeConn) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	c.execs <- sql
+	return pgconn.CommandTag{}, nil
+}
+
+func (c *fakeConn) WaitForNotification(ctx context.Context) (*pgconn.Notification, error) {
+	select {
+	case n := <-c.notifications:
+		return n, nil
+	case <-c.broken:
+		return nil, errBroken
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (*fakeConn) Close(context.Context) error { return nil }
+
+// newTestListener returns Listener which uses given connections,
+// nil connection means connection error.
+func newTestListener(cfg Config, conns <-chan *fakeConn) *Listener {
+	l := NewListener(cfg, &connectors.Raw{Query: "dsn"})
+	l.connect = func(ctx context.Context, _ string) (conn, error) {
+		select {
+		case c := <-conns:
+			if c == nil {
+				return nil, errConnect
+			}
+			return c, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return l
+}
+
+// recv returns value from ch or fails test after timeout.
+func recv[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+		panic("unreachable")
+	}
+}
+
+func TestListener_Subscribe(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	conns := make(chan *fakeConn, 1)
+	c := newFakeConn()
+	conns <- c
+	l := newTestListener(Config{}, conns)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := l.Subscribe(ctx, "a")
+	r.NoError(err)
+	_, err = l.Subscribe(ctx, "a")
+	r.ErrorIs(err, ErrAlreadySubscribed)
+
+	errc := make(chan error, 1)
+	go func() { errc <- l.Run(ctx) }()
+	r.Equal(`listen "a"`, recv(t, c.execs))
+
+	c.notifications <- &pgconn.Notification{PID: 1, Channel: "a", Payload: "payload"}
+	r.Equal(Notification{PID: 1, Channel: "a", Payload: "payload"}, recv(t, ch))
+
+	r.NoError(l.Unsubscribe("a"))
+	r.Equal(`unlisten "a"`, recv(t, c.execs))
+	_, ok := <-ch
+	r.False(ok)
+	r.ErrorIs(l.Unsubscribe("a"), ErrNotSubscribed)
+
+	_, err = l.Subscribe(ctx, "b")
+	r.NoError(err)
+	r.Equal(`listen "b"`, recv(t, c.execs))
+
+	cancel()
+	r.NoError(recv(t, errc))
+	_, err = l.Subscribe(ctx, "c")
+	r.ErrorIs(err, ErrClosed)
+}
+
+func TestListener_SlowSubscriber(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	conns := make(chan *fakeConn, 1)
+	c := newFakeConn()
+	conns <- c
+	l := newTestListener(Config{BufferSize: 1}, conns)
+
+	ch, err := l.Subscribe(context.Background(), "a")
+	r.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- l.Run(ctx) }()
+	r.Equal(`listen "a"`, recv(t, c.execs))
+
+	c.notifications <- &pgconn.Notification{Channel: "a", Payload: "1"}
+	c.notifications <- &pgconn.Notification{Channel: "a", Payload: "2"}
+	time.Sleep(10 * time.Millisecond) // Let Run block on full channel.
+	cancel()
+	r.NoError(recv(t, errc))
+
+	r.Equal(Notification{Channel: "a", Payload: "1"}, recv(t, ch))
+	_, ok := <-ch
+	r.False(ok)
+}
+
+func TestListener_Reconnect(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	conns := make(chan *fakeConn, 3)
+	first, second := newFakeConn(), newFakeConn()
+	conns <- nil
+	conns <- first
+	conns <- second
+
+	errs := make(chan error, 10)
+	connected := make(chan struct{}, 10)
+	l := newTestListener(Config{
+		MinReconnectInterval: time.Millisecond,
+		MaxReconnectInterval: time.Millisecond * 10,
+		OnConnect:            func() { connected <- struct{}{} },
+		OnError:              func(err error) { errs <- err },
+	}, conns)
+
+	received := make(chan Notification, 1)
+	r.NoError(l.Handle("a", func(n Notification) { received <- n }))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = l.Run(ctx) }()
+
+	r.ErrorIs(recv(t, errs), errConnect)
+	r.Equal(`listen "a"`, recv(t, first.execs))
+	recv(t, connected)
+
+	close(first.broken)
+	r.ErrorIs(recv(t, errs), errBroken)
+	r.Equal(`listen "a"`, recv(t, second.execs))
+	recv(t, connected)
+
+	second.notifications <- &pgconn.Notification{Channel: "a", Payload: "payload"}
+	r.Equal(Notification{Channel: "a", Payload: "payload"}, recv(t, received))
+}
+
+func TestListener_UnsubscribeInHandler(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	conns := make(chan *fakeConn, 1)
+	c := newFakeConn()
+	conns <- c
+	l := newTestListener(Config{}, conns)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := l.Subscribe(ctx, "b")
+	r.NoError(err)
+	errs := make(chan error, 2)
+	r.NoError(l.Handle("a", func(Notification) {
+		errs <- l.Unsubscribe("a")
+		errs <- l.Unsubscribe("b")
+	}))
+
+	go func() { _ = l.Run(ctx) }()
+	r.ElementsMatch([]string{`listen "a"`, `listen "b"`}, []string{recv(t, c.execs), recv(t, c.execs)})
+
+	c.notifications <- &pgconn.Notification{Channel: "a"}
+	r.NoError(recv(t, errs))
+	r.NoError(recv(t, errs))
+	_, ok := <-ch
+	r.False(ok)
+	r.ElementsMatch([]string{`unlisten "a"`, `unlisten "b"`}, []string{recv(t, c.execs), recv(t, c.execs)})
+}
@@ -0,0 +1,19 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Send sends notification inside given transaction.
+// Notification is delivered to listeners only when transaction is committed.
+func Send(ctx context.Context, tx *sqlx.Tx, channel, payload string) error {
+	_, err := tx.ExecContext(ctx, "select pg_notify($1, $2)", channel, payload)
+	if err != nil {
+		return fmt.Errorf("tx.ExecContext: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package notify_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database/notify"
+)
+
+func TestSend(t *testing.T) {
+	t.Parallel()
+
+	errAny := errors.New("any error")
+
+	testCases := map[string]struct {
+		err     error
+		wantErr error
+	}{
+		"success": {nil, nil},
+		"error":   {errAny, errAny},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			conn, mock, err := sqlmock.New()
+			r.NoError(err)
+			t.Cleanup(func() { r.NoError(conn.Close()) })
+
+			mock.ExpectBegin()
+			exec := mock.ExpectExec(`select pg_notify\(\$1, \$2\)`).WithArgs("channel", "payload")
+			if tc.err != nil {
+				exec.WillReturnError(tc.err)
+			} else {
+				exec.WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			ctx := context.Background()
+			tx, err := sqlx.NewDb(conn, "postgres").BeginTxx(ctx, nil)
+			r.NoError(err)
+
+			err = notify.Send(ctx, tx, "channel", "payload")
+			r.ErrorIs(err, tc.wantErr)
+			r.NoError(mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package outbox
+
+import (
+	"fmt"
+
+	"github.com/sipki-tech/database/migrations"
+)
+
+// Migration returns migration which creates table for outbox messages.
+// Version must be unique among other migrations of service.
+func Migration(version uint, tableName string) migrations.Migration {
+	return migrations.Migration{
+		Version: version,
+		Name:    "create_" + tableName + "_table",
+		Up: fmt.Sprintf(`create table %[1]s
+(
+    id           bigserial   not null,
+    topic        text        not null,
+    key          text        not null default '',
+    payload      bytea       not null,
+    attempts     integer     not null default 0,
+    locked_until timestamptz,
+    last_error   text,
+    created_at   timestamptz not null default now(),
+    sent_at      timestamptz,
+
+    primary key (id)
+);
+create index %[1]s_unsent_idx on %[1]s (key, id) where sent_at is null;`, tableName),
+		Down: fmt.Sprintf(`drop table %s;`, tableName),
+	}
+}
@@ -0,0 +1,114 @@
+// Package outbox contains transactional outbox for reliable event publishing.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/sipki-tech/database"
+)
+
+// Default values for config.
+const (
+	DefaultTableName     = "outbox"
+	DefaultBatchSize     = 100
+	DefaultPollInterval  = time.Second
+	DefaultLockTimeout   = time.Second * 30
+	DefaultMinRetryDelay = time.Second
+	DefaultMaxRetryDelay = time.Minute * 5
+)
+
+// ErrLeaseLost is returned when message was claimed by another relay after
+// lock timeout expired, so result of publishing isn't saved.
+var ErrLeaseLost = errors.New("message lease lost")
+
+// Config for set additional properties.
+type Config struct {
+	// TableName is a table created by Migration.
+	TableName string
+	// BatchSize is a max amount of messages claimed by relay at once.
+	BatchSize int
+	// PollInterval is an interval between polls when outbox is empty.
+	PollInterval time.Duration
+	// LockTimeout is a time for publishing claimed messages. When it
+	// expires messages may be claimed by another relay, result of late
+	// publishing isn't saved and ErrLeaseLost is reported to OnError.
+	LockTimeout time.Duration
+	// MinRetryDelay and MaxRetryDelay limits exponential backoff between
+	// attempts to publish message.
+	MinRetryDelay time.Duration
+	MaxRetryDelay time.Duration
+	// Retention of sent messages, zero means sent messages are kept forever.
+	Retention time.Duration
+	// OnError is called for every database or publishing error inside relay.
+	OnError func(error)
+}
+
+func (c Config) setDefault() Config {
+	if c.TableName == "" {
+		c.TableName = DefaultTableName
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = DefaultPollInterval
+	}
+	if c.LockTimeout == 0 {
+		c.LockTimeout = DefaultLockTimeout
+	}
+	if c.MinRetryDelay == 0 {
+		c.MinRetryDelay = DefaultMinRetryDelay
+	}
+	if c.MaxRetryDelay == 0 {
+		c.MaxRetryDelay = DefaultMaxRetryDelay
+	}
+	if c.OnError == nil {
+		c.OnError = func(error) {}
+	}
+	return c
+}
+
+// Message is an event for publishing.
+type Message struct {
+	ID    int64  `db:"id"`
+	Topic string `db:"topic"`
+	// Key of message, messages with same key are published in order of
+	// adding. Empty key is a valid key too.
+	Key       string    `db:"key"`
+	Payload   []byte    `db:"payload"`
+	Attempts  int       `db:"attempts"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Outbox stores messages inside business transactions and relays them
+// to Publisher after commit.
+type Outbox struct {
+	db  *database.SQL
+	cfg Config
+}
+
+// New build and returns new Outbox.
+func New(db *database.SQL, cfg Config) *Outbox {
+	return &Outbox{
+		db:  db,
+		cfg: cfg.setDefault(),
+	}
+}
+
+// Add stores message inside caller's transaction, so it's published
+// only if transaction is committed.
+func (o *Outbox) Add(ctx context.Context, tx *sqlx.Tx, topic, key string, payload []byte) error {
+	query := fmt.Sprintf(`insert into %s (topic, key, payload) values ($1, $2, $3)`, o.cfg.TableName)
+
+	_, err := tx.ExecContext(ctx, query, topic, key, payload)
+	if err != nil {
+		return fmt.Errorf("tx.ExecContext: %w", err)
+	}
+
+	return nil
+}
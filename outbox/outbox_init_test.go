@@ -0,0 +1,19 @@
+package outbox_test
+
+import (
+	"context"
+	"strings"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/sipki-tech/database/outbox"
+)
+
+// spaceInsensitive matches queries by regexp ignoring differences in whitespaces.
+var spaceInsensitive = sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+	return sqlmock.QueryMatcherRegexp.Match(expectedSQL, strings.Join(strings.Fields(actualSQL), " "))
+})
+
+type publisherFunc func(context.Context, outbox.Message) error
+
+func (f publisherFunc) Publish(ctx context.Context, msg outbox.Message) error { return f(ctx, msg) }
@@ -0,0 +1,104 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/connectors"
+	"github.com/sipki-tech/database/outbox"
+)
+
+func TestOutbox_Add(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	conn, mock, err := sqlmock.New()
+	r.NoError(err)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`insert into outbox \(topic, key, payload\)`).
+		WithArgs("user.created", "user_id", []byte("payload")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	ctx := context.Background()
+	tx, err := sqlx.NewDb(conn, "postgres").BeginTxx(ctx, nil)
+	r.NoError(err)
+
+	o := outbox.New(nil, outbox.Config{})
+	err = o.Add(ctx, tx, "user.created", "user_id", []byte("payload"))
+	r.NoError(err)
+	r.NoError(mock.ExpectationsWereMet())
+}
+
+func TestOutbox_Relay(t *testing.T) {
+	t.Parallel()
+
+	errAny := errors.New("any error")
+	msgColumns := []string{"id", "topic", "key", "payload", "attempts", "created_at"}
+
+	testCases := map[string]struct {
+		publishErr error
+		expect     func(sqlmock.Sqlmock)
+		wantErr    error
+	}{
+		"sent": {nil, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(`update outbox set sent_at = now\(\)`).
+				WithArgs(int64(1), 2).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, nil},
+		"retry": {errAny, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(`update outbox set locked_until`).
+				WithArgs(int64(1), 2, int64(2000), errAny.Error()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, errAny},
+		"lost": {nil, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(`update outbox set sent_at = now\(\)`).
+				WithArgs(int64(1), 2).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+		}, outbox.ErrLeaseLost},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			dsn := t.Name() + time.Now().String()
+			_, mock, err := sqlmock.NewWithDSN(dsn, sqlmock.QueryMatcherOption(spaceInsensitive))
+			r.NoError(err)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`with next as`).
+				WithArgs(outbox.DefaultBatchSize, outbox.DefaultLockTimeout.Milliseconds()).
+				WillReturnRows(sqlmock.NewRows(msgColumns).AddRow(1, "user.created", "user_id", []byte("payload"), 2, time.Now()))
+			mock.ExpectCommit()
+			tc.expect(mock)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+
+			db, err := database.NewSQL(ctx, "sqlmock", database.SQLConfig{}, &connectors.Raw{Query: dsn})
+			r.NoError(err)
+
+			o := outbox.New(db, outbox.Config{
+				OnError: func(err error) { r.ErrorIs(err, tc.wantErr) },
+			})
+			err = o.Relay(ctx, publisherFunc(func(_ context.Context, msg outbox.Message) error {
+				defer cancel()
+				r.Equal("user.created", msg.Topic)
+				r.Equal("user_id", msg.Key)
+				return tc.publishErr
+			}))
+			r.NoError(err)
+			r.NoError(mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/sipki-tech/database/internal"
+)
+
+// Publisher delivers messages to broker.
+type Publisher interface {
+	// Publish delivers message. It may be called concurrently for
+	// messages with different keys, but never for messages with same key.
+	Publish(ctx context.Context, msg Message) error
+}
+
+// Relay claims unsent messages, publishes them and marks them sent until
+// ctx is done. Many relays may work with same outbox, per key order
+// is preserved anyway, because only the oldest unsent message of every
+// key is claimed.
+func (o *Outbox) Relay(ctx context.Context, publisher Publisher) error {
+	lastCleanup := time.Time{}
+	for ctx.Err() == nil {
+		if o.cfg.Retention > 0 && time.Since(lastCleanup) > o.cfg.Retention {
+			err := o.cleanup(ctx)
+			if err != nil {
+				o.cfg.OnError(err)
+			} else {
+				lastCleanup = time.Now()
+			}
+		}
+
+		msgs, err := o.claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			o.cfg.OnError(err)
+		}
+		if len(msgs) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(o.cfg.PollInterval):
+			}
+			continue
+		}
+
+		o.publish(ctx, publisher, msgs)
+	}
+
+	return nil
+}
+
+// publish publishes claimed messages concurrently, every message has
+// unique key inside batch.
+func (o *Outbox) publish(ctx context.Context, publisher Publisher, msgs []Message) {
+	var wg sync.WaitGroup
+	wg.Add(len(msgs))
+	for i := range msgs {
+		go func(msg Message) {
+			defer wg.Done()
+
+			errPublish := publish(ctx, publisher, msg, o.cfg.LockTimeout)
+
+			// Save result even if ctx is done, otherwise message is
+			// published again after lock timeout.
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), o.cfg.LockTimeout)
+			defer cancel()
+
+			var err error
+			if errPublish == nil {
+				err = o.markSent(ctx, msg)
+			} else {
+				o.cfg.OnError(fmt.Errorf("publish %d: %w", msg.ID, errPublish))
+				err = o.retry(ctx, msg, errPublish)
+			}
+			if err != nil {
+				o.cfg.OnError(err)
+			}
+		}(msgs[i])
+	}
+	wg.Wait()
+}
+
+func publish(ctx context.Context, publisher Publisher, msg Message, timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	return publisher.Publish(ctx, msg)
+}
+
+// claim locks the oldest unsent message of every key for lock timeout.
+func (o *Outbox) claim(ctx context.Context) (msgs []Message, err error) {
+	query := fmt.Sprintf(`with next as (
+    select id
+    from %[1]s o
+    where sent_at is null
+      and (locked_until is null or locked_until < now())
+      and not exists(select 1 from %[1]s p where p.key = o.key and p.sent_at is null and p.id < o.id)
+    order by id
+    limit $1
+    for update skip locked
+)
+update %[1]s m
+set locked_until = now() + $2 * interval '1 millisecond',
+    attempts     = m.attempts + 1
+from next
+where m.id = next.id
+returning m.id, m.topic, m.key, m.payload, m.attempts, m.created_at`, o.cfg.TableName)
+
+	err = o.db.Tx(ctx, nil, func(tx *sqlx.Tx) error {
+		return tx.SelectContext(ctx, &msgs, query, o.cfg.BatchSize, o.cfg.LockTimeout.Milliseconds())
+	})
+
+	return msgs, err
+}
+
+func (o *Outbox) markSent(ctx context.Context, msg Message) error {
+	query := fmt.Sprintf(`update %s set sent_at = now(), locked_until = null where id = $1 and attempts = $2`, o.cfg.TableName)
+
+	return o.exec(ctx, msg, query, msg.ID, msg.Attempts)
+}
+
+// retry keeps message locked for backoff delay, so following messages
+// with same key aren't published before it.
+func (o *Outbox) retry(ctx context.Context, msg Message, errPublish error) error {
+	query := fmt.Sprintf(`update %s
+set locked_until = now() + $3 * interval '1 millisecond',
+    last_error   = $4
+where id = $1 and attempts = $2`, o.cfg.TableName)
+	delay := internal.Backoff(o.cfg.MinRetryDelay, o.cfg.MaxRetryDelay, msg.Attempts-1)
+
+	return o.exec(ctx, msg, query, msg.ID, msg.Attempts, delay.Milliseconds(), errPublish.Error())
+}
+
+// exec executes query changing claimed message and returns ErrLeaseLost
+// if message wasn't changed.
+func (o *Outbox) exec(ctx context.Context, msg Message, query string, args ...interface{}) error {
+	return o.db.NoTx(func(db *sqlx.DB) error {
+		res, err := db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("message %d: %w", msg.ID, ErrLeaseLost)
+		}
+		return nil
+	})
+}
+
+func (o *Outbox) cleanup(ctx context.Context) error {
+	query := fmt.Sprintf(`delete from %s where sent_at < now() - $1 * interval '1 millisecond'`, o.cfg.TableName)
+
+	return o.db.NoTx(func(db *sqlx.DB) error {
+		_, err := db.ExecContext(ctx, query, o.cfg.Retention.Milliseconds())
+		return err
+	})
+}
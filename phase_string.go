@@ -0,0 +1,189 @@
+// Code generated by "stringer -type=Phase,PanicPolicy,TxOutcome,TxPriority,QoS,PreparedStatements,LeakKind,PoolDecision,WriteTracking -linecomment"; DO NOT EDIT.
+
+package database
+
+import "strconv"
+
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[PhaseBegin-1]
+	_ = x[PhaseExec-2]
+	_ = x[PhaseCommit-3]
+	_ = x[PhaseRollback-4]
+	_ = x[PhaseAdmit-5]
+	_ = x[PhaseLSN-6]
+}
+
+const _Phase_name = "beginexeccommitrollbackadmitlsn"
+
+var _Phase_index = [...]uint8{0, 5, 9, 15, 23, 28, 31}
+
+func (i Phase) String() string {
+	idx := int(i) - 1
+	if i < 1 || idx >= len(_Phase_index)-1 {
+		return "Phase(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _Phase_name[_Phase_index[idx]:_Phase_index[idx+1]]
+}
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[PanicRepanic-1]
+	_ = x[PanicToError-2]
+	_ = x[PanicReport-3]
+}
+
+const _PanicPolicy_name = "repanicerrorreport"
+
+var _PanicPolicy_index = [...]uint8{0, 7, 12, 18}
+
+func (i PanicPolicy) String() string {
+	idx := int(i) - 1
+	if i < 1 || idx >= len(_PanicPolicy_index)-1 {
+		return "PanicPolicy(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _PanicPolicy_name[_PanicPolicy_index[idx]:_PanicPolicy_index[idx+1]]
+}
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[TxCommitted-1]
+	_ = x[TxRolledBack-2]
+	_ = x[TxRollbackFailed-3]
+	_ = x[TxPanicked-4]
+}
+
+const _TxOutcome_name = "committedrolled_backrollback_failedpanicked"
+
+var _TxOutcome_index = [...]uint8{0, 9, 20, 35, 43}
+
+func (i TxOutcome) String() string {
+	idx := int(i) - 1
+	if i < 1 || idx >= len(_TxOutcome_index)-1 {
+		return "TxOutcome(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _TxOutcome_name[_TxOutcome_index[idx]:_TxOutcome_index[idx+1]]
+}
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[TxPriorityLow-1]
+	_ = x[TxPriorityNormal-2]
+	_ = x[TxPriorityHigh-3]
+}
+
+const _TxPriority_name = "LOWNORMALHIGH"
+
+var _TxPriority_index = [...]uint8{0, 3, 9, 13}
+
+func (i TxPriority) String() string {
+	idx := int(i) - 1
+	if i < 1 || idx >= len(_TxPriority_index)-1 {
+		return "TxPriority(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _TxPriority_name[_TxPriority_index[idx]:_TxPriority_index[idx+1]]
+}
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[QoSBackground-1]
+	_ = x[QoSRegular-2]
+	_ = x[QoSCritical-3]
+}
+
+const _QoS_name = "backgroundregularcritical"
+
+var _QoS_index = [...]uint8{0, 10, 17, 25}
+
+func (i QoS) String() string {
+	idx := int(i) - 1
+	if i < 1 || idx >= len(_QoS_index)-1 {
+		return "QoS(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _QoS_name[_QoS_index[idx]:_QoS_index[idx+1]]
+}
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[PreparedDriver-1]
+	_ = x[PreparedCache-2]
+	_ = x[PreparedDisabled-3]
+}
+
+const _PreparedStatements_name = "drivercachedisabled"
+
+var _PreparedStatements_index = [...]uint8{0, 6, 11, 19}
+
+func (i PreparedStatements) String() string {
+	idx := int(i) - 1
+	if i < 1 || idx >= len(_PreparedStatements_index)-1 {
+		return "PreparedStatements(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _PreparedStatements_name[_PreparedStatements_index[idx]:_PreparedStatements_index[idx+1]]
+}
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[LeakRows-1]
+	_ = x[LeakStmt-2]
+	_ = x[LeakTx-3]
+}
+
+const _LeakKind_name = "rowsstatementtransaction"
+
+var _LeakKind_index = [...]uint8{0, 4, 13, 24}
+
+func (i LeakKind) String() string {
+	idx := int(i) - 1
+	if i < 1 || idx >= len(_LeakKind_index)-1 {
+		return "LeakKind(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _LeakKind_name[_LeakKind_index[idx]:_LeakKind_index[idx+1]]
+}
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[PoolGrow-1]
+	_ = x[PoolShrink-2]
+}
+
+const _PoolDecision_name = "growshrink"
+
+var _PoolDecision_index = [...]uint8{0, 4, 10}
+
+func (i PoolDecision) String() string {
+	idx := int(i) - 1
+	if i < 1 || idx >= len(_PoolDecision_index)-1 {
+		return "PoolDecision(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _PoolDecision_name[_PoolDecision_index[idx]:_PoolDecision_index[idx+1]]
+}
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[WriteTrackingLSN-1]
+	_ = x[WriteTrackingPrimary-2]
+	_ = x[WriteTrackingOff-3]
+}
+
+const _WriteTracking_name = "lsnprimaryoff"
+
+var _WriteTracking_index = [...]uint8{0, 3, 10, 13}
+
+func (i WriteTracking) String() string {
+	idx := int(i) - 1
+	if i < 1 || idx >= len(_WriteTracking_index)-1 {
+		return "WriteTracking(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _WriteTracking_name[_WriteTracking_index[idx]:_WriteTracking_index[idx+1]]
+}
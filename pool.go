@@ -0,0 +1,264 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/sipki-tech/database/dberr"
+	"github.com/sipki-tech/database/internal"
+)
+
+// Delays between pings while waiting until database is available.
+const (
+	minPingDelay = 10 * time.Millisecond
+	maxPingDelay = time.Second
+)
+
+// ErrSessionInit is returned if SQLConfig.SessionInit or SQLConfig.OnConnect
+// fails for new connection. NewSQL doesn't wait for database in this case
+// unless error is dberr.ErrConnection.
+var ErrSessionInit = errors.New("session init")
+
+// pool is a connection pool of SQL, it's replaced by SQL.Reconfigure
+// if DSN or connection settings are changed.
+type pool struct {
+	conn *sqlx.DB
+	dsn  string
+	// cfg is a config pool is opened with.
+	cfg SQLConfig
+	// deadlineTimeouts is nil if SQLConfig.DeadlineTimeouts and
+	// SQLConfig.NoTxDeadlineTimeouts are false.
+	deadlineTimeouts *deadlineTimeouts
+
+	// waiting is an amount of calls acquiring connection for SQL.Tx and SQL.Conn.
+	waiting atomic.Int64
+
+	mu       sync.Mutex
+	calls    int
+	draining bool
+	drained  chan struct{} // Closed when draining pool has no calls.
+}
+
+// isPermanent reports whether connection can't be made with same config,
+// so there is no reason to wait until database is available.
+func isPermanent(err error) bool {
+	return errors.Is(err, ErrPreparedUnsupported) ||
+		errors.Is(err, ErrSessionInit) && !errors.Is(err, dberr.ErrConnection)
+}
+
+// openPool opens new pool and waits until database is available.
+func (db *SQL) openPool(ctx context.Context, dsn string, cfg SQLConfig) (*pool, error) {
+	if cfg.NoTxDeadlineTimeouts && cfg.PreparedStatements == PreparedDisabled {
+		return nil, ErrSessionTimeouts
+	}
+
+	connMetrics := ConnCollector(NoMetric{})
+	if collector, ok := cfg.Metrics.(ConnCollector); ok {
+		connMetrics = collector
+	}
+	onConnect := func(ctx context.Context, session Session) (err error) {
+		defer func() { connMetrics.ConnInit(err) }()
+		for _, query := range cfg.SessionInit {
+			err = session.Exec(ctx, query)
+			if err != nil {
+				return fmt.Errorf("%w %q: %w", ErrSessionInit, query, dberr.Classify(err))
+			}
+		}
+		err = cfg.OnConnect(ctx, session)
+		if err != nil {
+			return fmt.Errorf("%w: OnConnect: %w", ErrSessionInit, err)
+		}
+		return nil
+	}
+
+	conn, err := openDB(db.driver, dsn, connConfig{
+		onConnect:     onConnect,
+		prepared:      cfg.PreparedStatements,
+		stmtCacheSize: cfg.StatementCacheSize,
+		leaks:         db.leaks,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("openDB: %w", err)
+	}
+
+	err = conn.PingContext(ctx)
+	for attempt := 0; err != nil && !isPermanent(err) && ctx.Err() == nil; attempt++ {
+		timer := time.NewTimer(internal.Backoff(minPingDelay, maxPingDelay, attempt))
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+			nextErr := conn.PingContext(ctx)
+			// Keep the reason why database is unavailable instead of ctx error.
+			if !errors.Is(nextErr, context.DeadlineExceeded) && !errors.Is(nextErr, context.Canceled) {
+				err = nextErr
+			}
+		}
+		timer.Stop()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("db.PingContext: %w", err)
+	}
+
+	p := &pool{
+		conn:    sqlx.NewDb(conn, db.driver),
+		dsn:     dsn,
+		cfg:     cfg,
+		drained: make(chan struct{}),
+	}
+	if cfg.DeadlineTimeouts || cfg.NoTxDeadlineTimeouts {
+		p.deadlineTimeouts = &deadlineTimeouts{
+			margin: cfg.DeadlineMargin,
+			tx:     cfg.DeadlineTimeouts,
+			noTx:   cfg.NoTxDeadlineTimeouts,
+		}
+	}
+	db.setLimits(p, cfg)
+	return p, nil
+}
+
+// setLimits applies pool limits of cfg, maximum of open and idle
+// connections is set by adaptive pool controller if it's enabled.
+func (db *SQL) setLimits(p *pool, cfg SQLConfig) {
+	p.conn.SetConnMaxLifetime(cfg.SetConnMaxLifetime)
+	p.conn.SetConnMaxIdleTime(cfg.SetConnMaxIdleTime)
+	if db.adaptive != nil {
+		db.adaptive.apply(p)
+		return
+	}
+	p.conn.SetMaxOpenConns(cfg.SetMaxOpenConnections)
+	p.conn.SetMaxIdleConns(cfg.SetMaxIdleConnections)
+}
+
+// acquire returns current pool, it isn't closed until release.
+func (db *SQL) acquire() *pool {
+	for {
+		p := db.pool.Load()
+		p.mu.Lock()
+		if !p.draining {
+			p.calls++
+			p.mu.Unlock()
+			return p
+		}
+		// Pool is replaced after it's loaded, new one is stored already.
+		p.mu.Unlock()
+	}
+}
+
+// inFlight returns amount of calls using pool.
+func (p *pool) inFlight() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func (p *pool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls--
+	if p.draining && p.calls == 0 {
+		close(p.drained)
+	}
+}
+
+// drain closes pool after all its calls are finished.
+func (p *pool) drain() error {
+	p.mu.Lock()
+	p.draining = true
+	if p.calls == 0 {
+		close(p.drained)
+	}
+	p.mu.Unlock()
+
+	<-p.drained
+	return p.conn.Close()
+}
+
+// Stats returns statistics of current pool.
+func (db *SQL) Stats() sql.DBStats {
+	return db.pool.Load().conn.Stats()
+}
+
+// withPool calls f with current pool, it's used for statements which
+// aren't made by DAL methods.
+func (db *SQL) withPool(f func(*pool) error) error {
+	p := db.acquire()
+	defer p.release()
+	return f(p)
+}
+
+// Reconfigure applies cfg and connector without restart.
+// Pool limits (SetConnMaxLifetime, SetConnMaxIdleTime, SetMaxOpenConnections
+// and SetMaxIdleConnections) are applied in place, maximum of open and
+// idle connections is kept if adaptive pool controller is enabled. If DSN or
+// connection settings (SessionInit, OnConnect, DeadlineTimeouts,
+// NoTxDeadlineTimeouts, DeadlineMargin, PreparedStatements and
+// StatementCacheSize) are changed, new pool is opened using cfg, it replaces current one for new calls and old pool is closed
+// in background after calls in progress are finished (Close waits for it).
+// Current pool is kept if new one can't be opened.
+// ReturnErrs, Metrics, PanicPolicy, OnPanic, TenantSchema, TenantSetting,
+// WriteTracking, Adaptive and leak detection settings can't be changed.
+// It returns sql.ErrConnDone after Close.
+func (db *SQL) Reconfigure(ctx context.Context, cfg SQLConfig, connector Connector) error {
+	cfg = cfg.setDefault()
+	cfg.Metrics = db.metrics
+
+	dsn, err := connector.DSN()
+	if err != nil {
+		return fmt.Errorf("connector.DSN: %w", err)
+	}
+
+	db.reconfigure.Lock()
+	defer db.reconfigure.Unlock()
+
+	if db.closed {
+		return sql.ErrConnDone
+	}
+
+	old := db.pool.Load()
+	if dsn == old.dsn && !connChanged(old.cfg, cfg) {
+		db.setLimits(old, cfg)
+		return nil
+	}
+
+	p, err := db.openPool(ctx, dsn, cfg)
+	if err != nil {
+		return err
+	}
+	db.pool.Store(p)
+
+	db.draining.Add(1)
+	go func() {
+		defer db.draining.Done()
+		err := old.drain()
+		if err != nil {
+			db.drainMu.Lock()
+			defer db.drainMu.Unlock()
+			db.drainErrs = append(db.drainErrs, err)
+		}
+	}()
+	return nil
+}
+
+// connChanged reports whether settings of connections are different, so
+// pool must be opened again for applying them. OnConnect is compared by
+// code, so changes of variables captured by closure aren't detected.
+func connChanged(a, b SQLConfig) bool {
+	return !slices.Equal(a.SessionInit, b.SessionInit) ||
+		reflect.ValueOf(a.OnConnect).Pointer() != reflect.ValueOf(b.OnConnect).Pointer() ||
+		a.DeadlineTimeouts != b.DeadlineTimeouts ||
+		a.NoTxDeadlineTimeouts != b.NoTxDeadlineTimeouts ||
+		a.DeadlineMargin != b.DeadlineMargin ||
+		a.PreparedStatements != b.PreparedStatements ||
+		a.StatementCacheSize != b.StatementCacheSize
+}
@@ -0,0 +1,147 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/connectors"
+)
+
+func TestSQL_Reconfigure(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	dsn := t.Name() + time.Now().String()
+	_, mock, err := sqlmock.NewWithDSN(dsn)
+	r.NoError(err)
+	db, err := database.NewSQL(ctx, "sqlmock", database.SQLConfig{}, &connectors.Raw{Query: dsn})
+	r.NoError(err)
+	r.Equal(database.DefaultSetMaxOpenConnections, db.Stats().MaxOpenConnections)
+
+	// Same DSN: limits are applied in place.
+	err = db.Reconfigure(ctx, database.SQLConfig{SetMaxOpenConnections: 5}, &connectors.Raw{Query: dsn})
+	r.NoError(err)
+	r.Equal(5, db.Stats().MaxOpenConnections)
+
+	// Call in progress keeps old pool.
+	started, finish, done := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		done <- db.NoTx(func(db *sqlx.DB) error {
+			close(started)
+			<-finish
+			_, err := db.Exec("update old")
+			return err
+		})
+	}()
+	<-started
+
+	newDSN := dsn + "new"
+	_, newMock, err := sqlmock.NewWithDSN(newDSN)
+	r.NoError(err)
+	err = db.Reconfigure(ctx, database.SQLConfig{SetMaxOpenConnections: 7}, &connectors.Raw{Query: newDSN})
+	r.NoError(err)
+	r.Equal(7, db.Stats().MaxOpenConnections)
+
+	newMock.ExpectExec("update new").WillReturnResult(sqlmock.NewResult(0, 1))
+	r.NoError(db.NoTx(func(db *sqlx.DB) error {
+		_, err := db.Exec("update new")
+		return err
+	}))
+	r.NoError(newMock.ExpectationsWereMet())
+
+	mock.ExpectExec("update old").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectClose()
+	close(finish)
+	r.NoError(<-done)
+	r.Eventually(func() bool { return mock.ExpectationsWereMet() == nil }, time.Second, time.Millisecond*10)
+
+	// Current pool is kept if new one can't be opened.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = db.Reconfigure(canceled, database.SQLConfig{}, &connectors.Raw{Query: "unknown"})
+	r.Error(err)
+	r.Equal(7, db.Stats().MaxOpenConnections)
+
+	newMock.ExpectClose()
+	r.NoError(db.Close())
+}
+
+func TestSQL_ReconfigureConnSettings(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	dsn := t.Name() + time.Now().String()
+	_, mock, err := sqlmock.NewWithDSN(dsn)
+	r.NoError(err)
+	db, err := database.NewSQL(ctx, "sqlmock", database.SQLConfig{}, &connectors.Raw{Query: dsn})
+	r.NoError(err)
+
+	// Same DSN, but new connections must execute SessionInit.
+	cfg := database.SQLConfig{SessionInit: []string{"SET TIME ZONE 'UTC'"}}
+	mock.ExpectExec("SET TIME ZONE 'UTC'").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectClose()
+	r.NoError(db.Reconfigure(ctx, cfg, &connectors.Raw{Query: dsn}))
+	r.Eventually(func() bool { return mock.ExpectationsWereMet() == nil }, time.Second, time.Millisecond*10)
+
+	// Nothing is changed.
+	r.NoError(db.Reconfigure(ctx, cfg, &connectors.Raw{Query: dsn}))
+
+	mock.ExpectClose()
+	r.NoError(db.Close())
+	r.NoError(mock.ExpectationsWereMet())
+
+	err = db.Reconfigure(ctx, cfg, &connectors.Raw{Query: dsn})
+	r.ErrorIs(err, sql.ErrConnDone)
+}
+
+func TestSQL_CloseDraining(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	dsn := t.Name() + time.Now().String()
+	_, mock, err := sqlmock.NewWithDSN(dsn)
+	r.NoError(err)
+	db, err := database.NewSQL(ctx, "sqlmock", database.SQLConfig{}, &connectors.Raw{Query: dsn})
+	r.NoError(err)
+
+	started, finish, done := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		done <- db.NoTx(func(*sqlx.DB) error {
+			close(started)
+			<-finish
+			return nil
+		})
+	}()
+	<-started
+
+	newDSN := dsn + "new"
+	_, newMock, err := sqlmock.NewWithDSN(newDSN)
+	r.NoError(err)
+	r.NoError(db.Reconfigure(ctx, database.SQLConfig{}, &connectors.Raw{Query: newDSN}))
+
+	mock.ExpectClose()
+	newMock.ExpectClose()
+	closed := make(chan error)
+	go func() { closed <- db.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before calls of draining pool are finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(finish)
+	r.NoError(<-done)
+	r.NoError(<-closed)
+	r.NoError(mock.ExpectationsWereMet())
+	r.NoError(newMock.ExpectationsWereMet())
+}
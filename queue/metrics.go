@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Results of job processing.
+const (
+	ResultDone  = "done"  // Job is processed and deleted.
+	ResultRetry = "retry" // Job is failed and will be retried.
+	ResultDead  = "dead"  // Job is failed too many times and dead-lettered.
+	ResultLost  = "lost"  // Job is claimed by another worker, result is discarded.
+)
+
+// MetricCollector collects metrics for queues.
+type MetricCollector interface {
+	// Enqueued is called for every enqueued job.
+	Enqueued(queue string)
+	// Processed is called for every processed job.
+	Processed(queue, result string, duration time.Duration)
+}
+
+const (
+	labelQueue  = "queue"  // Value: queue name.
+	labelResult = "result" // Value: ResultDone, ResultRetry, ResultDead or ResultLost.
+)
+
+var _ MetricCollector = Metrics{}
+
+// Metrics contains per-queue metrics.
+type Metrics struct {
+	enqueuedTotal  *prometheus.CounterVec
+	processedTotal *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+}
+
+// NewMetrics registers and returns queue metrics for given queues.
+func NewMetrics(reg prometheus.Registerer, namespace, subsystem string, queues ...string) (metric Metrics) {
+	metric.enqueuedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "queue_enqueued_total",
+			Help:      "Amount of enqueued jobs.",
+		},
+		[]string{labelQueue},
+	)
+	reg.MustRegister(metric.enqueuedTotal)
+	metric.processedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "queue_processed_total",
+			Help:      "Amount of processed jobs.",
+		},
+		[]string{labelQueue, labelResult},
+	)
+	reg.MustRegister(metric.processedTotal)
+	metric.duration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "queue_processing_duration_seconds",
+			Help:      "Job processing latency.",
+		},
+		[]string{labelQueue},
+	)
+	reg.MustRegister(metric.duration)
+
+	for _, queue := range queues {
+		metric.enqueuedTotal.With(prometheus.Labels{labelQueue: queue})
+		metric.duration.With(prometheus.Labels{labelQueue: queue})
+		for _, result := range []string{ResultDone, ResultRetry, ResultDead, ResultLost} {
+			metric.processedTotal.With(prometheus.Labels{labelQueue: queue, labelResult: result})
+		}
+	}
+
+	return metric
+}
+
+// Enqueued implements MetricCollector.
+func (m Metrics) Enqueued(queue string) {
+	m.enqueuedTotal.With(prometheus.Labels{labelQueue: queue}).Inc()
+}
+
+// Processed implements MetricCollector.
+func (m Metrics) Processed(queue, result string, duration time.Duration) {
+	m.processedTotal.With(prometheus.Labels{labelQueue: queue, labelResult: result}).Inc()
+	m.duration.With(prometheus.Labels{labelQueue: queue}).Observe(duration.Seconds())
+}
+
+var _ MetricCollector = NoMetric{}
+
+// NoMetric if you want to turn off metrics.
+type NoMetric struct{}
+
+// Enqueued implements MetricCollector.
+func (NoMetric) Enqueued(string) {}
+
+// Processed implements MetricCollector.
+func (NoMetric) Processed(string, string, time.Duration) {}
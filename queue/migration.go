@@ -0,0 +1,33 @@
+package queue
+
+import (
+	"fmt"
+
+	"github.com/sipki-tech/database/migrations"
+)
+
+// Migration returns migration which creates table for jobs.
+// Version must be unique among other migrations of service.
+func Migration(version uint, tableName string) migrations.Migration {
+	return migrations.Migration{
+		Version: version,
+		Name:    "create_" + tableName + "_table",
+		Up: fmt.Sprintf(`create table %[1]s
+(
+    id           bigserial   not null,
+    queue        text        not null,
+    payload      bytea       not null,
+    status       text        not null default 'pending',
+    attempts     integer     not null default 0,
+    max_attempts integer     not null,
+    run_at       timestamptz not null default now(),
+    locked_until timestamptz,
+    last_error   text,
+    created_at   timestamptz not null default now(),
+
+    primary key (id)
+);
+create index %[1]s_dequeue_idx on %[1]s (queue, run_at) where status = 'pending';`, tableName),
+		Down: fmt.Sprintf(`drop table %s;`, tableName),
+	}
+}
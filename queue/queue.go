@@ -0,0 +1,137 @@
+// Package queue contains durable job queue built on top of database.
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/sipki-tech/database"
+)
+
+// Default values for config.
+const (
+	DefaultTableName         = "queue_job"
+	DefaultMaxAttempts       = 10
+	DefaultVisibilityTimeout = time.Second * 30
+	DefaultPollInterval      = time.Second
+	DefaultMinRetryDelay     = time.Second
+	DefaultMaxRetryDelay     = time.Hour
+)
+
+// Config for set additional properties.
+type Config struct {
+	// TableName is a table created by Migration.
+	TableName string
+	// MaxAttempts before job is dead-lettered, may be overridden by Message.MaxAttempts.
+	MaxAttempts int
+	// VisibilityTimeout is a time for processing job. When it expires
+	// job is visible for other workers again, so Handler's context has
+	// same timeout.
+	VisibilityTimeout time.Duration
+	// PollInterval is an interval between dequeue attempts when queue is empty.
+	PollInterval time.Duration
+	// MinRetryDelay and MaxRetryDelay limits exponential backoff between attempts.
+	MinRetryDelay time.Duration
+	MaxRetryDelay time.Duration
+	Metrics       MetricCollector
+	// OnError is called for every database error inside workers.
+	OnError func(error)
+}
+
+func (c Config) setDefault() Config {
+	if c.TableName == "" {
+		c.TableName = DefaultTableName
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.VisibilityTimeout == 0 {
+		c.VisibilityTimeout = DefaultVisibilityTimeout
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = DefaultPollInterval
+	}
+	if c.MinRetryDelay == 0 {
+		c.MinRetryDelay = DefaultMinRetryDelay
+	}
+	if c.MaxRetryDelay == 0 {
+		c.MaxRetryDelay = DefaultMaxRetryDelay
+	}
+	if c.Metrics == nil {
+		c.Metrics = NoMetric{}
+	}
+	if c.OnError == nil {
+		c.OnError = func(error) {}
+	}
+	return c
+}
+
+// Message for enqueueing.
+type Message struct {
+	Queue   string
+	Payload []byte
+	// RunAt is the earliest time for processing, zero means now.
+	RunAt time.Time
+	// MaxAttempts overrides Config.MaxAttempts if not zero.
+	MaxAttempts int
+}
+
+// Job is a dequeued message.
+type Job struct {
+	ID       int64  `db:"id"`
+	Queue    string `db:"queue"`
+	Payload  []byte `db:"payload"`
+	Attempts int    `db:"attempts"`
+	// MaxAttempts is a limit of attempts, after which job is dead-lettered.
+	MaxAttempts int       `db:"max_attempts"`
+	RunAt       time.Time `db:"run_at"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// Queue is a durable job queue.
+type Queue struct {
+	db  *database.SQL
+	cfg Config
+}
+
+// New build and returns new Queue.
+func New(db *database.SQL, cfg Config) *Queue {
+	return &Queue{
+		db:  db,
+		cfg: cfg.setDefault(),
+	}
+}
+
+// Enqueue adds message inside caller's transaction, so job becomes
+// visible for workers only when transaction is committed. Job is counted
+// by MetricCollector.Enqueued after commit if tx is started by
+// database.SQL.Tx, otherwise it's counted immediately.
+func (q *Queue) Enqueue(ctx context.Context, tx *sqlx.Tx, msg Message) (id int64, err error) {
+	maxAttempts := msg.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = q.cfg.MaxAttempts
+	}
+
+	var runAt *time.Time
+	if !msg.RunAt.IsZero() {
+		runAt = &msg.RunAt
+	}
+
+	query := fmt.Sprintf(`insert into %s (queue, payload, max_attempts, run_at)
+values ($1, $2, $3, coalesce($4, now()))
+returning id`, q.cfg.TableName)
+
+	err = tx.GetContext(ctx, &id, query, msg.Queue, msg.Payload, maxAttempts, runAt)
+	if err != nil {
+		return 0, fmt.Errorf("tx.GetContext: %w", err)
+	}
+	enqueued := func() { q.cfg.Metrics.Enqueued(msg.Queue) }
+	if !database.OnCommit(tx, enqueued) {
+		enqueued()
+	}
+
+	return id, nil
+}
@@ -0,0 +1,12 @@
+package queue_test
+
+import (
+	"strings"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// spaceInsensitive matches queries by regexp ignoring differences in whitespaces.
+var spaceInsensitive = sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+	return sqlmock.QueryMatcherRegexp.Match(expectedSQL, strings.Join(strings.Fields(actualSQL), " "))
+})
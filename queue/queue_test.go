@@ -0,0 +1,194 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/connectors"
+	"github.com/sipki-tech/database/queue"
+)
+
+func TestQueue_Enqueue(t *testing.T) {
+	t.Parallel()
+
+	runAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := map[string]struct {
+		msg             queue.Message
+		wantMaxAttempts int
+		wantRunAt       interface{}
+	}{
+		"default": {queue.Message{Queue: "mail", Payload: []byte("payload")}, queue.DefaultMaxAttempts, nil},
+		"custom":  {queue.Message{Queue: "mail", Payload: []byte("payload"), RunAt: runAt, MaxAttempts: 3}, 3, runAt},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			conn, mock, err := sqlmock.New()
+			r.NoError(err)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`insert into queue_job`).
+				WithArgs(tc.msg.Queue, tc.msg.Payload, tc.wantMaxAttempts, tc.wantRunAt).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+
+			ctx := context.Background()
+			tx, err := sqlx.NewDb(conn, "postgres").BeginTxx(ctx, nil)
+			r.NoError(err)
+
+			q := queue.New(nil, queue.Config{})
+			id, err := q.Enqueue(ctx, tx, tc.msg)
+			r.NoError(err)
+			r.Equal(int64(42), id)
+			r.NoError(mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestQueue_Work(t *testing.T) {
+	t.Parallel()
+
+	errAny := errors.New("any error")
+	jobColumns := []string{"id", "queue", "payload", "attempts", "max_attempts", "run_at", "created_at"}
+
+	testCases := map[string]struct {
+		attempts   int
+		handlerErr error
+		expect     func(sqlmock.Sqlmock)
+		wantErr    error
+	}{
+		"done": {1, nil, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(`delete from queue_job where id = \$1 and attempts = \$2`).
+				WithArgs(int64(1), 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, nil},
+		"retry": {1, errAny, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(`update queue_job set run_at`).
+				WithArgs(int64(1), 1, int64(1000), errAny.Error()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, nil},
+		"dead": {3, errAny, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(`update queue_job set status = 'dead'`).
+				WithArgs(int64(1), 3, errAny.Error()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, nil},
+		"lost": {1, nil, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(`delete from queue_job where id = \$1 and attempts = \$2`).
+				WithArgs(int64(1), 1).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+		}, queue.ErrLeaseLost},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			dsn := t.Name() + time.Now().String()
+			_, mock, err := sqlmock.NewWithDSN(dsn, sqlmock.QueryMatcherOption(spaceInsensitive))
+			r.NoError(err)
+
+			now := time.Now()
+			mock.ExpectBegin()
+			mock.ExpectQuery(`with next as`).
+				WithArgs("mail", queue.DefaultVisibilityTimeout.Milliseconds()).
+				WillReturnRows(sqlmock.NewRows(jobColumns).AddRow(1, "mail", []byte("payload"), tc.attempts, 3, now, now))
+			mock.ExpectCommit()
+			tc.expect(mock)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+
+			db, err := database.NewSQL(ctx, "sqlmock", database.SQLConfig{}, &connectors.Raw{Query: dsn})
+			r.NoError(err)
+
+			q := queue.New(db, queue.Config{
+				PollInterval: time.Hour,
+				OnError: func(err error) {
+					if tc.wantErr == nil {
+						r.NoError(err)
+					} else {
+						r.ErrorIs(err, tc.wantErr)
+					}
+				},
+			})
+			err = q.Work(ctx, "mail", 1, func(_ context.Context, job queue.Job) error {
+				defer cancel()
+				r.Equal([]byte("payload"), job.Payload)
+				return tc.handlerErr
+			})
+			r.NoError(err)
+			r.NoError(mock.ExpectationsWereMet())
+		})
+	}
+}
+
+type enqueuedCounter struct {
+	queue.NoMetric
+	enqueued int
+}
+
+func (c *enqueuedCounter) Enqueued(string) { c.enqueued++ }
+
+func TestQueue_EnqueueAfterCommit(t *testing.T) {
+	t.Parallel()
+
+	errAny := errors.New("any error")
+
+	testCases := map[string]struct {
+		txErr        error
+		wantEnqueued int
+	}{
+		"commit":   {nil, 1},
+		"rollback": {errAny, 0},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			dsn := t.Name() + time.Now().String()
+			_, mock, err := sqlmock.NewWithDSN(dsn)
+			r.NoError(err)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`insert into queue_job`).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+			if tc.txErr == nil {
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			ctx := context.Background()
+			db, err := database.NewSQL(ctx, "sqlmock", database.SQLConfig{}, &connectors.Raw{Query: dsn})
+			r.NoError(err)
+
+			metrics := &enqueuedCounter{}
+			q := queue.New(db, queue.Config{Metrics: metrics})
+			err = db.Tx(ctx, nil, func(tx *sqlx.Tx) error {
+				_, err := q.Enqueue(ctx, tx, queue.Message{Queue: "mail"})
+				r.NoError(err)
+				r.Zero(metrics.enqueued)
+				return tc.txErr
+			})
+			r.ErrorIs(err, tc.txErr)
+			r.Equal(tc.wantEnqueued, metrics.enqueued)
+			r.NoError(mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/sipki-tech/database/internal"
+)
+
+// ErrLeaseLost is returned when job was claimed by another worker after
+// visibility timeout expired, so result of processing is discarded.
+var ErrLeaseLost = errors.New("job lease lost")
+
+// Handler processes job. Returned error means job will be retried or
+// dead-lettered if there are no attempts left.
+type Handler func(ctx context.Context, job Job) error
+
+// Work starts given amount of workers for queue and blocks until ctx is done.
+// Jobs are dequeued with SELECT ... FOR UPDATE SKIP LOCKED, so any amount
+// of workers in any amount of instances may process same queue.
+func (q *Queue) Work(ctx context.Context, queue string, workers int, h Handler) error {
+	if workers < 1 {
+		return fmt.Errorf("invalid amount of workers: %d", workers)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			q.worker(ctx, queue, h)
+		}()
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func (q *Queue) worker(ctx context.Context, queue string, h Handler) {
+	for ctx.Err() == nil {
+		job, err := q.claim(ctx, queue)
+		if err != nil && ctx.Err() == nil {
+			q.cfg.OnError(err)
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(q.cfg.PollInterval):
+			}
+			continue
+		}
+
+		err = q.process(ctx, job, h)
+		if err != nil {
+			q.cfg.OnError(err)
+		}
+	}
+}
+
+// process calls handler and saves result of processing.
+func (q *Queue) process(ctx context.Context, job *Job, h Handler) error {
+	start := time.Now()
+	var errHandler error
+	if job.Attempts > job.MaxAttempts {
+		// Previous attempts were lost (e.g. worker was killed).
+		errHandler = fmt.Errorf("too many attempts: %d", job.Attempts)
+	} else {
+		errHandler = q.handle(ctx, job, h)
+	}
+
+	// Save result even if ctx is done, otherwise job is retried after
+	// visibility timeout.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), q.cfg.VisibilityTimeout)
+	defer cancel()
+
+	var err error
+	result := ResultDone
+	switch {
+	case errHandler == nil:
+		err = q.complete(ctx, job)
+	case job.Attempts >= job.MaxAttempts:
+		result = ResultDead
+		err = q.bury(ctx, job, errHandler)
+	default:
+		result = ResultRetry
+		err = q.retry(ctx, job, errHandler)
+	}
+	if errors.Is(err, ErrLeaseLost) {
+		result = ResultLost
+	}
+	q.cfg.Metrics.Processed(job.Queue, result, time.Since(start))
+
+	return err
+}
+
+func (q *Queue) handle(ctx context.Context, job *Job, h Handler) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, q.cfg.VisibilityTimeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	return h(ctx, *job)
+}
+
+// claim dequeues one job and hides it from other workers for visibility timeout.
+func (q *Queue) claim(ctx context.Context, queue string) (job *Job, err error) {
+	query := fmt.Sprintf(`with next as (
+    select id
+    from %[1]s
+    where queue = $1
+      and status = 'pending'
+      and run_at <= now()
+      and (locked_until is null or locked_until < now())
+    order by run_at, id
+    limit 1
+    for update skip locked
+)
+update %[1]s j
+set locked_until = now() + $2 * interval '1 millisecond',
+    attempts     = j.attempts + 1
+from next
+where j.id = next.id
+returning j.id, j.queue, j.payload, j.attempts, j.max_attempts, j.run_at, j.created_at`, q.cfg.TableName)
+
+	err = q.db.Tx(ctx, nil, func(tx *sqlx.Tx) error {
+		var claimed Job
+		err := tx.GetContext(ctx, &claimed, query, queue, q.cfg.VisibilityTimeout.Milliseconds())
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil
+		case err != nil:
+			return err
+		}
+
+		job = &claimed
+		return nil
+	})
+
+	return job, err
+}
+
+// complete, retry and bury change job only if it wasn't claimed again,
+// every claim increments attempts, so it's used as a lease token.
+func (q *Queue) complete(ctx context.Context, job *Job) error {
+	query := fmt.Sprintf(`delete from %s where id = $1 and attempts = $2`, q.cfg.TableName)
+
+	return q.exec(ctx, job, query, job.ID, job.Attempts)
+}
+
+func (q *Queue) retry(ctx context.Context, job *Job, errHandler error) error {
+	query := fmt.Sprintf(`update %s
+set run_at       = now() + $3 * interval '1 millisecond',
+    locked_until = null,
+    last_error   = $4
+where id = $1
+  and attempts = $2`, q.cfg.TableName)
+	delay := internal.Backoff(q.cfg.MinRetryDelay, q.cfg.MaxRetryDelay, job.Attempts-1)
+
+	return q.exec(ctx, job, query, job.ID, job.Attempts, delay.Milliseconds(), errHandler.Error())
+}
+
+// bury moves job to dead-letter status, such jobs are never dequeued.
+func (q *Queue) bury(ctx context.Context, job *Job, errHandler error) error {
+	query := fmt.Sprintf(`update %s
+set status       = 'dead',
+    locked_until = null,
+    last_error   = $3
+where id = $1
+  and attempts = $2`, q.cfg.TableName)
+
+	return q.exec(ctx, job, query, job.ID, job.Attempts, errHandler.Error())
+}
+
+// exec executes query changing claimed job and returns ErrLeaseLost
+// if job wasn't changed.
+func (q *Queue) exec(ctx context.Context, job *Job, query string, args ...interface{}) error {
+	return q.db.NoTx(func(db *sqlx.DB) error {
+		res, err := db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("job %d: %w", job.ID, ErrLeaseLost)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,265 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/sipki-tech/database/dberr"
+	"github.com/sipki-tech/database/internal"
+)
+
+// Default values for router config.
+const (
+	DefaultHealthCheckInterval = time.Second * 5
+	DefaultHealthCheckTimeout  = time.Second
+)
+
+// RouterConfig for set additional properties of Router.
+type RouterConfig struct {
+	// HealthCheckInterval is an interval between replica pings in Router.Run.
+	HealthCheckInterval time.Duration
+	// HealthCheckTimeout limits every replica ping and every query of
+	// replayed LSN made by reads with ctx created by WithReadYourWrites.
+	HealthCheckTimeout time.Duration
+	// FallbackToPrimary makes reads retry on primary when replica
+	// connection fails. Read callbacks are called twice in this case, so
+	// they must be idempotent and must not have side effects.
+	FallbackToPrimary bool
+	// TrackReplicaLag enables checking of replayed LSN and replication lag
+	// of PostgreSQL replicas together with health checks.
+	TrackReplicaLag bool
+	// Metrics receives replication lag if it implements ReplicaCollector.
+	Metrics MetricCollector
+	// OnError is called when replica becomes unhealthy.
+	OnError func(replica int, err error)
+}
+
+func (c RouterConfig) setDefault() RouterConfig {
+	if c.HealthCheckInterval == 0 {
+		c.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+	if c.HealthCheckTimeout == 0 {
+		c.HealthCheckTimeout = DefaultHealthCheckTimeout
+	}
+	if c.Metrics == nil {
+		c.Metrics = NoMetric{}
+	}
+	if c.OnError == nil {
+		c.OnError = func(int, error) {}
+	}
+	return c
+}
+
+// Router splits reads and writes between primary and replicas.
+// Every pool is a separate SQL created by NewSQL with own Connector.
+//
+// Reads with ctx created by WithReadYourWrites use only replicas which
+// have replayed all writes made with this ctx (PostgreSQL only).
+//
+// Replica which connection fails is unhealthy until Run checks it or
+// until one of reads after HealthCheckInterval succeeds on it.
+type Router struct {
+	cfg        RouterConfig
+	primary    *SQL
+	replicas   []*replica
+	next       atomic.Uint64
+	lagMetrics ReplicaCollector
+}
+
+type replica struct {
+	index   int
+	db      *SQL
+	healthy atomic.Bool
+	retryAt atomic.Int64  // Unix nano time when unhealthy replica may be tried by read.
+	lsn     atomic.Uint64 // Last known replayed LSN.
+}
+
+// available returns true if replica is healthy or it's time to try
+// unhealthy replica again, only one caller tries it.
+func (r *replica) available(retryInterval time.Duration) bool {
+	if r.healthy.Load() {
+		return true
+	}
+	now := time.Now()
+	retryAt := r.retryAt.Load()
+	return now.UnixNano() >= retryAt && r.retryAt.CompareAndSwap(retryAt, now.Add(retryInterval).UnixNano())
+}
+
+// NewRouter returns Router for given pools. All replicas are considered
+// healthy until Run finds out otherwise.
+func NewRouter(primary *SQL, replicas []*SQL, cfg RouterConfig) *Router {
+	cfg = cfg.setDefault()
+	r := &Router{
+		cfg:        cfg,
+		primary:    primary,
+		replicas:   make([]*replica, len(replicas)),
+		lagMetrics: NoMetric{},
+	}
+	if lagMetrics, ok := cfg.Metrics.(ReplicaCollector); ok {
+		r.lagMetrics = lagMetrics
+	}
+	for i := range replicas {
+		r.replicas[i] = &replica{index: i, db: replicas[i]}
+		r.replicas[i].healthy.Store(true)
+	}
+	return r
+}
+
+// Close closes all pools.
+func (r *Router) Close() error {
+	errs := []error{r.primary.Close()}
+	for _, replica := range r.replicas {
+		errs = append(errs, replica.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Primary returns primary pool.
+func (r *Router) Primary() *SQL {
+	return r.primary
+}
+
+// NoTx is like SQL.NoTx on primary.
+func (r *Router) NoTx(f func(*sqlx.DB) error) error {
+	return r.primary.NoTxNamed(internal.CallerMethodName(1), f)
+}
+
+// Tx is like SQL.Tx on primary.
+func (r *Router) Tx(ctx context.Context, opts *sql.TxOptions, f func(*sqlx.Tx) error) error {
+	return r.primary.TxNamed(ctx, internal.CallerMethodName(1), opts, f)
+}
+
+// ReadNoTx is like SQL.NoTxContext on one of healthy replicas, f must not
+// modify data. Replicas are used in round-robin order, primary is used if
+// there are no healthy replicas or if replica connection fails and
+// RouterConfig.FallbackToPrimary is set.
+func (r *Router) ReadNoTx(ctx context.Context, f func(context.Context, *sqlx.DB) error) error {
+	methodName := internal.CallerMethodName(1)
+	return r.read(ctx, func(db *SQL) error {
+		return db.NoTxContextNamed(ctx, methodName, f)
+	})
+}
+
+// ReadTx is like SQL.Tx with read only transaction on one of healthy
+// replicas, see ReadNoTx.
+func (r *Router) ReadTx(ctx context.Context, f func(*sqlx.Tx) error) error {
+	methodName := internal.CallerMethodName(1)
+	return r.read(ctx, func(db *SQL) error {
+		return db.TxNamed(ctx, methodName, &sql.TxOptions{ReadOnly: true}, f)
+	})
+}
+
+func (r *Router) read(ctx context.Context, f func(*SQL) error) error {
+	replica := r.pick(ctx)
+	if replica == nil {
+		return f(r.primary)
+	}
+
+	err := f(replica.db)
+	if !errors.Is(err, dberr.ErrConnection) {
+		if !replica.healthy.Load() {
+			r.setHealthy(replica, nil) // Restored without Run.
+		}
+		return err
+	}
+
+	r.setHealthy(replica, fmt.Errorf("read: %w", err))
+	if r.cfg.FallbackToPrimary {
+		return f(r.primary)
+	}
+	return err
+}
+
+// pick returns next healthy replica which has replayed writes made with
+// ctx or nil. Replica which fails to return its LSN is marked unhealthy.
+func (r *Router) pick(ctx context.Context) *replica {
+	required := ReadYourWritesLSN(ctx)
+	if required == lsnUnknown {
+		return nil
+	}
+
+	n := uint64(len(r.replicas))
+	start := r.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		replica := r.replicas[(start+i)%n]
+		if !replica.available(r.cfg.HealthCheckInterval) {
+			continue
+		}
+		if LSN(replica.lsn.Load()) >= required {
+			return replica
+		}
+		statusCtx, cancel := context.WithTimeout(ctx, r.cfg.HealthCheckTimeout)
+		err := r.updateStatus(statusCtx, replica)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != nil:
+			r.setHealthy(replica, err)
+		case LSN(replica.lsn.Load()) >= required:
+			return replica
+		}
+	}
+	return nil
+}
+
+// updateStatus updates replayed LSN and replication lag of replica.
+func (r *Router) updateStatus(ctx context.Context, replica *replica) error {
+	lsn, lag, err := replicaStatus(ctx, replica.db)
+	if err != nil {
+		return fmt.Errorf("replicaStatus: %w", err)
+	}
+	replica.lsn.Store(uint64(lsn))
+	r.lagMetrics.ReplicaLag(strconv.Itoa(replica.index), lag)
+	return nil
+}
+
+// Run checks replicas health until ctx is done.
+func (r *Router) Run(ctx context.Context) error {
+	t := time.NewTicker(r.cfg.HealthCheckInterval)
+	defer t.Stop()
+
+	for {
+		r.checkHealth(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func (r *Router) checkHealth(ctx context.Context) {
+	for _, replica := range r.replicas {
+		ctx, cancel := context.WithTimeout(ctx, r.cfg.HealthCheckTimeout)
+		err := replica.db.withPool(func(p *pool) error {
+			return p.conn.PingContext(ctx)
+		})
+		if err != nil {
+			err = fmt.Errorf("ping: %w", err)
+		} else if r.cfg.TrackReplicaLag {
+			err = r.updateStatus(ctx, replica)
+		}
+		cancel()
+		r.setHealthy(replica, err)
+	}
+}
+
+// setHealthy marks replica healthy if err is nil and unhealthy otherwise.
+func (r *Router) setHealthy(replica *replica, err error) {
+	if err != nil {
+		replica.retryAt.Store(time.Now().Add(r.cfg.HealthCheckInterval).UnixNano())
+	}
+	wasHealthy := replica.healthy.Swap(err == nil)
+	if err != nil && wasHealthy {
+		r.cfg.OnError(replica.index, err)
+	}
+}
@@ -0,0 +1,204 @@
+package database_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/dberr"
+)
+
+func TestRouter(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	primary, primaryMock := start(t, database.SQLConfig{})
+	replica, replicaMock := start(t, database.SQLConfig{})
+	var unhealthy []int
+	router := database.NewRouter(primary, []*database.SQL{replica}, database.RouterConfig{
+		HealthCheckInterval: time.Hour,
+		FallbackToPrimary:   true,
+		OnError:             func(replica int, _ error) { unhealthy = append(unhealthy, replica) },
+	})
+	repo := routerRepo{router}
+	rows := func() *sqlmock.Rows { return sqlmock.NewRows([]string{"id"}).AddRow(1) }
+
+	replicaMock.ExpectQuery("select").WillReturnRows(rows())
+	ids, err := repo.Get(ctx)
+	r.NoError(err)
+	r.Equal([]int{1}, ids)
+
+	replicaMock.ExpectQuery("select").WillReturnError(&net.OpError{Op: "read", Net: "tcp", Err: io.ErrUnexpectedEOF})
+	primaryMock.ExpectQuery("select").WillReturnRows(rows())
+	_, err = repo.Get(ctx)
+	r.NoError(err)
+	r.Equal([]int{0}, unhealthy)
+
+	primaryMock.ExpectQuery("select").WillReturnRows(rows())
+	_, err = repo.Get(ctx)
+	r.NoError(err)
+
+	ctxRun, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	r.NoError(router.Run(ctxRun))
+	replicaMock.ExpectQuery("select").WillReturnRows(rows())
+	_, err = repo.Get(ctx)
+	r.NoError(err)
+
+	primaryMock.ExpectBegin()
+	primaryMock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	primaryMock.ExpectCommit()
+	r.NoError(repo.Update(ctx))
+
+	r.NoError(primaryMock.ExpectationsWereMet())
+	r.NoError(replicaMock.ExpectationsWereMet())
+}
+
+func TestRouter_ReadYourWrites(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	reg := prometheus.NewPedanticRegistry()
+	primary, primaryMock := start(t, database.SQLConfig{})
+	replica, replicaMock := start(t, database.SQLConfig{})
+	router := database.NewRouter(primary, []*database.SQL{replica}, database.RouterConfig{
+		HealthCheckInterval: time.Hour,
+		TrackReplicaLag:     true,
+		Metrics:             database.NewMetrics(reg, "test", "db"),
+	})
+	repo := routerRepo{router}
+	rows := func() *sqlmock.Rows { return sqlmock.NewRows([]string{"id"}).AddRow(1) }
+	status := func(lsn string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"lsn", "lag"}).AddRow(lsn, 1.5)
+	}
+
+	replicaMock.ExpectQuery("pg_last_wal_replay_lsn").WillReturnRows(status("0/10"))
+	ctxRun, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r.NoError(router.Run(ctxRun))
+	r.Equal(1.5, metricValue(t, reg, "test_db_replica_lag_seconds", prometheus.Labels{"replica": "0"}))
+
+	ctx := database.WithReadYourWrites(context.Background(), 0)
+	primaryMock.ExpectBegin()
+	primaryMock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	primaryMock.ExpectCommit()
+	primaryMock.ExpectQuery("pg_current_wal_lsn").WillReturnRows(sqlmock.NewRows([]string{"lsn"}).AddRow("0/20"))
+	r.NoError(repo.Update(ctx))
+	r.Equal(database.LSN(0x20), database.ReadYourWritesLSN(ctx))
+
+	replicaMock.ExpectQuery("pg_last_wal_replay_lsn").WillReturnRows(status("0/18"))
+	primaryMock.ExpectQuery("select").WillReturnRows(rows())
+	_, err := repo.Get(ctx)
+	r.NoError(err)
+
+	replicaMock.ExpectQuery("pg_last_wal_replay_lsn").WillReturnRows(status("0/20"))
+	replicaMock.ExpectQuery("select").WillReturnRows(rows())
+	_, err = repo.Get(ctx)
+	r.NoError(err)
+
+	replicaMock.ExpectQuery("select").WillReturnRows(rows())
+	_, err = repo.Get(ctx)
+	r.NoError(err)
+
+	r.NoError(primaryMock.ExpectationsWereMet())
+	r.NoError(replicaMock.ExpectationsWereMet())
+}
+
+func TestRouter_ReadYourWritesReadTx(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	primary, primaryMock := start(t, database.SQLConfig{})
+	replica, replicaMock := start(t, database.SQLConfig{})
+	router := database.NewRouter(primary, []*database.SQL{replica}, database.RouterConfig{
+		HealthCheckInterval: time.Hour,
+	})
+	repo := routerRepo{router}
+	rows := func() *sqlmock.Rows { return sqlmock.NewRows([]string{"id"}).AddRow(1) }
+
+	ctx := database.WithReadYourWrites(context.Background(), 0)
+	replicaMock.ExpectBegin()
+	replicaMock.ExpectQuery("select").WillReturnRows(rows())
+	replicaMock.ExpectCommit()
+	_, err := repo.GetTx(ctx)
+	r.NoError(err)
+	r.Equal(database.LSN(0), database.ReadYourWritesLSN(ctx))
+
+	replicaMock.ExpectQuery("select").WillReturnRows(rows())
+	_, err = repo.Get(ctx)
+	r.NoError(err)
+
+	r.NoError(primaryMock.ExpectationsWereMet())
+	r.NoError(replicaMock.ExpectationsWereMet())
+}
+
+func TestRouter_ReadYourWritesStatusErr(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	primary, primaryMock := start(t, database.SQLConfig{})
+	replica, replicaMock := start(t, database.SQLConfig{})
+	var unhealthy []int
+	router := database.NewRouter(primary, []*database.SQL{replica}, database.RouterConfig{
+		HealthCheckInterval: time.Hour,
+		HealthCheckTimeout:  10 * time.Millisecond,
+		OnError:             func(replica int, _ error) { unhealthy = append(unhealthy, replica) },
+	})
+	repo := routerRepo{router}
+
+	ctx := database.WithReadYourWrites(context.Background(), 0x20)
+	replicaMock.ExpectQuery("pg_last_wal_replay_lsn").WillDelayFor(time.Second)
+	primaryMock.ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	_, err := repo.Get(ctx)
+	r.NoError(err)
+	r.Equal([]int{0}, unhealthy)
+
+	primaryMock.ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	_, err = repo.Get(ctx)
+	r.NoError(err)
+
+	r.NoError(primaryMock.ExpectationsWereMet())
+	r.NoError(replicaMock.ExpectationsWereMet())
+}
+
+func TestRouter_LazyRestore(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	primary, primaryMock := start(t, database.SQLConfig{})
+	replica, replicaMock := start(t, database.SQLConfig{})
+	router := database.NewRouter(primary, []*database.SQL{replica}, database.RouterConfig{
+		HealthCheckInterval: 50 * time.Millisecond,
+	})
+	repo := routerRepo{router}
+	rows := func() *sqlmock.Rows { return sqlmock.NewRows([]string{"id"}).AddRow(1) }
+	errConn := &net.OpError{Op: "read", Net: "tcp", Err: io.ErrUnexpectedEOF}
+
+	replicaMock.ExpectQuery("select").WillReturnError(errConn)
+	_, err := repo.Get(ctx)
+	r.ErrorIs(err, dberr.ErrConnection)
+
+	primaryMock.ExpectQuery("select").WillReturnRows(rows())
+	_, err = repo.Get(ctx)
+	r.NoError(err)
+
+	time.Sleep(60 * time.Millisecond)
+	replicaMock.ExpectQuery("select").WillReturnRows(rows())
+	replicaMock.ExpectQuery("select").WillReturnRows(rows())
+	_, err = repo.Get(ctx)
+	r.NoError(err)
+	_, err = repo.Get(ctx)
+	r.NoError(err)
+
+	r.NoError(primaryMock.ExpectationsWereMet())
+	r.NoError(replicaMock.ExpectationsWereMet())
+}
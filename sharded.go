@@ -0,0 +1,262 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sipki-tech/database/internal"
+)
+
+// DefaultVirtualNodes is an amount of points of every shard on hash ring.
+const DefaultVirtualNodes = 128
+
+// Errors.
+var (
+	ErrNoShards        = errors.New("no shards")
+	ErrShardOutOfRange = errors.New("shard index out of range")
+)
+
+// ShardMapper maps shard key to shard index.
+type ShardMapper interface {
+	// Shard returns shard index in range [0, shards).
+	Shard(key string) int
+}
+
+// HashRing is a consistent hashing ShardMapper: adding shard moves only
+// about 1/N of keys.
+type HashRing struct {
+	size   int
+	points []uint64
+	shards []int
+}
+
+// NewHashRing returns HashRing for given amount of shards, virtualNodes
+// defaults to DefaultVirtualNodes.
+func NewHashRing(shards, virtualNodes int) *HashRing {
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+
+	type point struct {
+		hash  uint64
+		shard int
+	}
+	points := make([]point, 0, shards*virtualNodes)
+	for shard := 0; shard < shards; shard++ {
+		for i := 0; i < virtualNodes; i++ {
+			points = append(points, point{hash: hash(strconv.Itoa(shard) + "-" + strconv.Itoa(i)), shard: shard})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	ring := &HashRing{
+		size:   shards,
+		points: make([]uint64, len(points)),
+		shards: make([]int, len(points)),
+	}
+	for i := range points {
+		ring.points[i] = points[i].hash
+		ring.shards[i] = points[i].shard
+	}
+	return ring
+}
+
+// Shard implements ShardMapper.
+func (r *HashRing) Shard(key string) int {
+	if len(r.points) == 0 {
+		return 0
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.shards[i]
+}
+
+// hash returns FNV-1a hash with avalanche mixing, because FNV alone
+// distributes short similar keys poorly.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Ranges is a ShardMapper with explicit ranges: it contains sorted lower
+// bounds of shards, key belongs to the last shard with lower bound <= key.
+// Keys are compared as strings, so numeric keys must have same width.
+// Keys less than first bound belong to the first shard.
+type Ranges []string
+
+// validate checks that bounds are sorted and there is bound for every shard.
+func (r Ranges) validate(shards int) error {
+	if len(r) != shards {
+		return fmt.Errorf("%d ranges for %d shards", len(r), shards)
+	}
+	for i := 1; i < len(r); i++ {
+		if r[i-1] >= r[i] {
+			return fmt.Errorf("ranges aren't sorted: %q >= %q", r[i-1], r[i])
+		}
+	}
+	return nil
+}
+
+// Shard implements ShardMapper.
+func (r Ranges) Shard(key string) int {
+	i := sort.Search(len(r), func(i int) bool { return r[i] > key })
+	if i == 0 {
+		return 0
+	}
+	return i - 1
+}
+
+// ShardedConfig for set additional properties of ShardedSQL.
+type ShardedConfig struct {
+	// SQL is a config for every shard.
+	SQL SQLConfig
+	// Metrics are created for every shard with additional "shard" const
+	// label, SQL.Metrics is used as is if Metrics is nil.
+	Metrics *MetricsConfig
+	// Mapper by default is HashRing with DefaultVirtualNodes.
+	// HashRing and Ranges must be built for same amount of shards as
+	// amount of connectors.
+	Mapper ShardMapper
+}
+
+// ShardedSQL splits data across several databases by shard key.
+type ShardedSQL struct {
+	shards []*SQL
+	mapper ShardMapper
+}
+
+// NewShardedSQL connects to every shard and returns ShardedSQL.
+func NewShardedSQL(ctx context.Context, driver string, cfg ShardedConfig, connectors ...Connector) (_ *ShardedSQL, err error) {
+	if len(connectors) == 0 {
+		return nil, ErrNoShards
+	}
+	switch mapper := cfg.Mapper.(type) {
+	case nil:
+		cfg.Mapper = NewHashRing(len(connectors), DefaultVirtualNodes)
+	case *HashRing:
+		if mapper.size != len(connectors) {
+			return nil, fmt.Errorf("hash ring of %d shards for %d shards", mapper.size, len(connectors))
+		}
+	case Ranges:
+		err := mapper.validate(len(connectors))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s := &ShardedSQL{mapper: cfg.Mapper}
+	defer func() {
+		if err != nil {
+			_ = s.Close()
+		}
+	}()
+
+	for i, connector := range connectors {
+		shardCfg := cfg.SQL
+		if cfg.Metrics != nil {
+			metricsCfg := *cfg.Metrics
+			metricsCfg.ConstLabels = prometheus.Labels{labelShard: strconv.Itoa(i)}
+			for name, value := range cfg.Metrics.ConstLabels {
+				metricsCfg.ConstLabels[name] = value
+			}
+			shardCfg.Metrics = NewMetricsWithConfig(metricsCfg)
+		}
+
+		db, err := NewSQL(ctx, driver, shardCfg, connector)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %w", i, err)
+		}
+		s.shards = append(s.shards, db)
+	}
+
+	return s, nil
+}
+
+// Close closes all shards.
+func (s *ShardedSQL) Close() error {
+	errs := make([]error, len(s.shards))
+	for i := range s.shards {
+		errs[i] = s.shards[i].Close()
+	}
+	return errors.Join(errs...)
+}
+
+// Shard returns shard for given key, ErrShardOutOfRange is returned if
+// Mapper returns invalid index.
+func (s *ShardedSQL) Shard(key string) (*SQL, error) {
+	i := s.mapper.Shard(key)
+	if i < 0 || i >= len(s.shards) {
+		return nil, fmt.Errorf("%w: %d of %d", ErrShardOutOfRange, i, len(s.shards))
+	}
+	return s.shards[i], nil
+}
+
+// NoTx is like SQL.NoTx on shard for given key.
+func (s *ShardedSQL) NoTx(key string, f func(*sqlx.DB) error) error {
+	db, err := s.Shard(key)
+	if err != nil {
+		return err
+	}
+	return db.NoTxNamed(internal.CallerMethodName(1), f)
+}
+
+// Tx is like SQL.Tx on shard for given key.
+func (s *ShardedSQL) Tx(ctx context.Context, key string, opts *sql.TxOptions, f func(*sqlx.Tx) error) error {
+	db, err := s.Shard(key)
+	if err != nil {
+		return err
+	}
+	return db.TxNamed(ctx, internal.CallerMethodName(1), opts, f)
+}
+
+// Gather runs f on every shard in parallel (like SQL.NoTxContext) and
+// returns merged results in shard order. Results of failed shards are
+// skipped and their errors are joined.
+func Gather[T any](ctx context.Context, s *ShardedSQL, f func(context.Context, *sqlx.DB) ([]T, error)) ([]T, error) {
+	methodName := internal.CallerMethodName(1)
+
+	results := make([][]T, len(s.shards))
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	wg.Add(len(s.shards))
+	for i := range s.shards {
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.shards[i].NoTxContextNamed(ctx, methodName, func(ctx context.Context, db *sqlx.DB) (err error) {
+				results[i], err = f(ctx, db)
+				return err
+			})
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("shard %d: %w", i, errs[i])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var merged []T
+	for i := range results {
+		if errs[i] == nil {
+			merged = append(merged, results[i]...)
+		}
+	}
+	return merged, errors.Join(errs...)
+}
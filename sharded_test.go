@@ -0,0 +1,174 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/connectors"
+)
+
+func TestHashRing(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	const keys = 10000
+	small, big := database.NewHashRing(4, 0), database.NewHashRing(5, 0)
+	counts := make([]int, 4)
+	moved := 0
+	for i := 0; i < keys; i++ {
+		key := strconv.Itoa(i)
+		shard := small.Shard(key)
+		counts[shard]++
+		r.Equal(shard, small.Shard(key))
+		if newShard := big.Shard(key); newShard != shard {
+			r.Equal(4, newShard)
+			moved++
+		}
+	}
+	for _, count := range counts {
+		r.InDelta(keys/4, count, keys/10)
+	}
+	r.InDelta(keys/5, moved, keys/10)
+}
+
+func TestRanges(t *testing.T) {
+	t.Parallel()
+
+	ranges := database.Ranges{"000", "100", "200"}
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"", 0},
+		{"000", 0},
+		{"099", 0},
+		{"100", 1},
+		{"150", 1},
+		{"200", 2},
+		{"999", 2},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.key, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			r.Equal(tc.want, ranges.Shard(tc.key))
+		})
+	}
+}
+
+func TestShardedSQL(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	var (
+		conns []database.Connector
+		mocks []sqlmock.Sqlmock
+	)
+	for i := 0; i < 2; i++ {
+		dsn := t.Name() + strconv.Itoa(i) + time.Now().String()
+		_, mock, err := sqlmock.NewWithDSN(dsn)
+		r.NoError(err)
+		conns = append(conns, &connectors.Raw{Query: dsn})
+		mocks = append(mocks, mock)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	db, err := database.NewShardedSQL(ctx, "sqlmock", database.ShardedConfig{
+		Metrics: &database.MetricsConfig{Registerer: reg, Namespace: "test", Subsystem: "db"},
+		Mapper:  database.Ranges{"a", "n"},
+	}, conns...)
+	r.NoError(err)
+	repo := shardedRepo{db}
+
+	mocks[1].ExpectBegin()
+	mocks[1].ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	mocks[1].ExpectCommit()
+	r.NoError(repo.Update(ctx, "tenant"))
+	r.Equal(1.0, metricValue(t, reg, "test_db_call_duration_seconds", prometheus.Labels{"func": "Update", "shard": "1"}))
+	r.Equal(0.0, metricValue(t, reg, "test_db_call_duration_seconds", prometheus.Labels{"func": "Update", "shard": "0"}))
+
+	mocks[0].ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mocks[1].ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	ids, err := repo.List(ctx)
+	r.NoError(err)
+	r.Equal([]int{1, 2, 3}, ids)
+
+	errAny := errors.New("any error")
+	mocks[0].ExpectQuery("select").WillReturnError(errAny)
+	mocks[1].ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	ids, err = repo.List(ctx)
+	r.ErrorIs(err, errAny)
+	r.ErrorContains(err, "shard 0")
+	r.Equal([]int{3}, ids)
+
+	for _, mock := range mocks {
+		r.NoError(mock.ExpectationsWereMet())
+		mock.ExpectClose()
+	}
+	r.NoError(db.Close())
+}
+
+func TestNewShardedSQL_Invalid(t *testing.T) {
+	t.Parallel()
+
+	conns := []database.Connector{&connectors.Raw{Query: "unused"}, &connectors.Raw{Query: "unused"}}
+	testCases := map[string]struct {
+		mapper database.ShardMapper
+		conns  []database.Connector
+	}{
+		"no_shards":         {nil, nil},
+		"ranges_count":      {database.Ranges{"a"}, conns},
+		"ranges_not_sorted": {database.Ranges{"n", "a"}, conns},
+		"hash_ring_count":   {database.NewHashRing(3, 0), conns},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			db, err := database.NewShardedSQL(context.Background(), "sqlmock", database.ShardedConfig{Mapper: tc.mapper}, tc.conns...)
+			r.Error(err)
+			r.Nil(db)
+		})
+	}
+}
+
+type constMapper int
+
+func (m constMapper) Shard(string) int { return int(m) }
+
+func TestShardedSQL_OutOfRange(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	dsn := t.Name() + time.Now().String()
+	_, mock, err := sqlmock.NewWithDSN(dsn)
+	r.NoError(err)
+
+	db, err := database.NewShardedSQL(context.Background(), "sqlmock", database.ShardedConfig{
+		Mapper: constMapper(1),
+	}, &connectors.Raw{Query: dsn})
+	r.NoError(err)
+
+	_, err = db.Shard("key")
+	r.ErrorIs(err, database.ErrShardOutOfRange)
+	r.ErrorIs(db.NoTx("key", func(*sqlx.DB) error { return nil }), database.ErrShardOutOfRange)
+
+	mock.ExpectClose()
+	r.NoError(db.Close())
+	r.NoError(mock.ExpectationsWereMet())
+}
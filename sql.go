@@ -3,11 +3,16 @@ package database
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+
 	"github.com/sipki-tech/database/internal"
 )
 
@@ -21,8 +26,61 @@ const (
 
 // SQLConfig for set additional properties.
 type SQLConfig struct {
-	ReturnErrs            []error
-	Metrics               MetricCollector
+	ReturnErrs []error
+	Metrics    MetricCollector
+	// PanicPolicy defines handling of panics inside DAL methods,
+	// by default panics are propagated after rollback.
+	PanicPolicy PanicPolicy
+	// OnPanic is called for every panic if PanicPolicy is PanicReport.
+	OnPanic func(method string, err *PanicError)
+	// SessionInit are statements executed on every new connection before
+	// it's used, e.g. "SET TIME ZONE 'UTC'" or "SET statement_timeout = '5s'".
+	SessionInit []string
+	// OnConnect is called for every new connection after SessionInit.
+	// Connection is discarded if SessionInit or OnConnect fails (see ErrSessionInit).
+	OnConnect func(ctx context.Context, session Session) error
+	// TenantSchema returns schema of tenant for SQL.SchemaTx, by default
+	// schema name is same as tenant.
+	TenantSchema func(tenant string) string
+	// TenantSetting is set to tenant by SQL.RLSTx, by default DefaultTenantSetting.
+	TenantSetting string
+	// DeadlineTimeouts makes Tx set statement_timeout and lock_timeout
+	// locally to time left until ctx deadline minus DeadlineMargin,
+	// so server aborts statements which client doesn't wait for anymore.
+	DeadlineTimeouts bool
+	// NoTxDeadlineTimeouts is like DeadlineTimeouts, but for NoTxContext.
+	// Timeouts are set for session before every statement and previous
+	// values are restored when connection returns to pool, so every
+	// statement with deadline costs extra round trip. Session settings are
+	// unsafe with transaction pooling, so it can't be used with
+	// PreparedDisabled (see ErrSessionTimeouts).
+	NoTxDeadlineTimeouts bool
+	// DeadlineMargin is a time required for client to receive result,
+	// by default DefaultDeadlineMargin.
+	DeadlineMargin time.Duration
+	// PreparedStatements defines usage of server-side prepared statements,
+	// by default PreparedDriver.
+	PreparedStatements PreparedStatements
+	// StatementCacheSize is a maximum amount of statements cached by every
+	// connection if PreparedStatements is PreparedCache,
+	// by default DefaultStatementCacheSize.
+	StatementCacheSize int
+	// DetectLeaks enables debug mode which tracks rows, statements and
+	// transactions with stack trace of their creation (see SQL.Leaks and
+	// CloseNoLeaks). It's expensive, so it's intended for tests and debugging.
+	DetectLeaks bool
+	// LeakTimeout is an age of rows, statement or transaction after which
+	// it's reported to OnLeak, by default DefaultLeakTimeout.
+	LeakTimeout time.Duration
+	// OnLeak is called once for every rows, statement or transaction which
+	// isn't closed after LeakTimeout if DetectLeaks is set.
+	OnLeak func(leak Leak)
+	// WriteTracking defines recording of writes made by Tx with ctx
+	// created by WithReadYourWrites, by default WriteTrackingLSN.
+	WriteTracking WriteTracking
+	// Adaptive enables adaptive pool size and load shedding (see SQL.Run),
+	// SetMaxOpenConnections is an initial pool size in this case.
+	Adaptive              *AdaptiveConfig
 	SetConnMaxLifetime    time.Duration
 	SetConnMaxIdleTime    time.Duration
 	SetMaxOpenConnections int
@@ -33,6 +91,39 @@ func (c SQLConfig) setDefault() SQLConfig {
 	if c.Metrics == nil {
 		c.Metrics = NoMetric{}
 	}
+	if c.PanicPolicy == 0 {
+		c.PanicPolicy = PanicRepanic
+	}
+	if c.OnPanic == nil {
+		c.OnPanic = func(string, *PanicError) {}
+	}
+	if c.OnConnect == nil {
+		c.OnConnect = func(context.Context, Session) error { return nil }
+	}
+	if c.TenantSchema == nil {
+		c.TenantSchema = func(tenant string) string { return tenant }
+	}
+	if c.TenantSetting == "" {
+		c.TenantSetting = DefaultTenantSetting
+	}
+	if c.DeadlineMargin == 0 {
+		c.DeadlineMargin = DefaultDeadlineMargin
+	}
+	if c.PreparedStatements == 0 {
+		c.PreparedStatements = PreparedDriver
+	}
+	if c.WriteTracking == 0 {
+		c.WriteTracking = WriteTrackingLSN
+	}
+	if c.StatementCacheSize == 0 {
+		c.StatementCacheSize = DefaultStatementCacheSize
+	}
+	if c.LeakTimeout == 0 {
+		c.LeakTimeout = DefaultLeakTimeout
+	}
+	if c.OnLeak == nil {
+		c.OnLeak = func(Leak) {}
+	}
 	if c.SetConnMaxLifetime == 0 {
 		c.SetConnMaxLifetime = DefaultSetConnMaxLifetime
 	}
@@ -56,9 +147,31 @@ type Connector interface {
 
 // SQL is a wrapper for sql database.
 type SQL struct {
-	conn       *sqlx.DB
-	returnErrs []error
-	metrics    MetricCollector
+	driver      string
+	pool        atomic.Pointer[pool]
+	returnErrs  []error
+	metrics     MetricCollector
+	txMetrics   TxCollector
+	rowsMetrics RowsCollector
+	panicPolicy PanicPolicy
+	onPanic     func(method string, err *PanicError)
+
+	tenantSchema  func(tenant string) string
+	tenantSetting string
+	writeTracking WriteTracking
+
+	// leaks is nil if SQLConfig.DetectLeaks is false.
+	leaks *leakTracker
+	// adaptive is nil if SQLConfig.Adaptive is nil.
+	adaptive    *adaptive
+	poolMetrics PoolCollector
+	// reconfigure serializes Reconfigure and Close calls.
+	reconfigure sync.Mutex
+	closed      bool // Protected by reconfigure.
+	// draining are pools replaced by Reconfigure which aren't closed yet.
+	draining  sync.WaitGroup
+	drainMu   sync.Mutex
+	drainErrs []error
 }
 
 // NewSQL build and returns new SQL client.
@@ -70,82 +183,284 @@ func NewSQL(ctx context.Context, driver string, cfg SQLConfig, connector Connect
 		return nil, fmt.Errorf("connector.DSN: %w", err)
 	}
 
-	conn, err := sql.Open(driver, dsn)
-	if err != nil {
-		return nil, fmt.Errorf("sql.Open: %w", err)
-	}
+	db := &SQL{
+		driver:      driver,
+		returnErrs:  cfg.ReturnErrs,
+		metrics:     cfg.Metrics,
+		txMetrics:   NoMetric{},
+		rowsMetrics: NoMetric{},
+		poolMetrics: NoMetric{},
+		panicPolicy: cfg.PanicPolicy,
+		onPanic:     cfg.OnPanic,
 
-	err = conn.PingContext(ctx)
-	for err != nil {
-		nextErr := conn.PingContext(ctx)
-		if errors.Is(nextErr, context.DeadlineExceeded) || errors.Is(nextErr, context.Canceled) {
-			return nil, fmt.Errorf("db.PingContext: %w", err)
-		}
-		err = nextErr
+		tenantSchema:  cfg.TenantSchema,
+		tenantSetting: cfg.TenantSetting,
+		writeTracking: cfg.WriteTracking,
+	}
+	if cfg.DetectLeaks {
+		db.leaks = newLeakTracker(cfg.LeakTimeout, cfg.OnLeak)
+	}
+	if cfg.Adaptive != nil {
+		db.adaptive = newAdaptive(cfg.Adaptive.setDefault(cfg.SetMaxOpenConnections), cfg.SetMaxOpenConnections)
 	}
 
-	db := &SQL{
-		conn:       sqlx.NewDb(conn, driver),
-		returnErrs: cfg.ReturnErrs,
-		metrics:    cfg.Metrics,
+	if txMetrics, ok := cfg.Metrics.(TxCollector); ok {
+		db.txMetrics = txMetrics
+	}
+	if rowsMetrics, ok := cfg.Metrics.(RowsCollector); ok {
+		db.rowsMetrics = rowsMetrics
+	}
+	if poolMetrics, ok := cfg.Metrics.(PoolCollector); ok {
+		db.poolMetrics = poolMetrics
 	}
 
-	db.conn.SetConnMaxLifetime(cfg.SetConnMaxLifetime)
-	db.conn.SetConnMaxIdleTime(cfg.SetConnMaxIdleTime)
-	db.conn.SetMaxOpenConns(cfg.SetMaxOpenConnections)
-	db.conn.SetMaxIdleConns(cfg.SetMaxIdleConnections)
+	p, err := db.openPool(ctx, dsn, cfg)
+	if err != nil {
+		return nil, err
+	}
+	db.pool.Store(p)
 
 	return db, nil
 }
 
 // Close implements io.Closer.
+// It also waits until calls of pools replaced by Reconfigure are finished
+// and returns errors of closing them.
 func (db *SQL) Close() error {
-	return db.conn.Close()
+	db.reconfigure.Lock()
+	defer db.reconfigure.Unlock()
+
+	db.closed = true
+	err := db.pool.Load().conn.Close()
+	db.draining.Wait()
+
+	db.drainMu.Lock()
+	defer db.drainMu.Unlock()
+	return errors.Join(append([]error{err}, db.drainErrs...)...)
 }
 
 // NoTx provides DAL method wrapper with:
 // - converting sqlx errors which are actually bugs into panics,
 // - general metrics for DAL methods,
-// - wrapping errors with DAL method name.
+// - wrapping errors into *DALError with DAL method name,
+// - classifying driver errors (see dberr package),
+// - handling panics according to SQLConfig.PanicPolicy.
+// Rows aren't counted and RowsCollector isn't called, because statements
+// have no context of call, use NoTxContext for this.
 func (db *SQL) NoTx(f func(*sqlx.DB) error) (err error) {
-	methodName := internal.CallerMethodName(1)
-	return db.metrics.Collecting(methodName, func() error {
-		err := f(db.conn)
-		if err != nil {
-			err = fmt.Errorf("%s: %w", methodName, err)
-		}
-		return err
-	})()
+	return db.NoTxNamed(internal.CallerMethodName(1), f)
+}
+
+// NoTxNamed is like NoTx, but uses given method name instead of caller's
+// one. It's useful for closures and functions which aren't DAL methods.
+func (db *SQL) NoTxNamed(methodName string, f func(*sqlx.DB) error) (err error) {
+	return db.run(methodName, func(c *call) error {
+		return f(c.pool.conn)
+	})
+}
+
+// NoTxContext is like NoTx, but f receives ctx which should be used for
+// all statements, it allows counting rows returned and affected by call
+// and setting timeouts from ctx deadline (see SQLConfig.NoTxDeadlineTimeouts).
+func (db *SQL) NoTxContext(ctx context.Context, f func(context.Context, *sqlx.DB) error) (err error) {
+	return db.NoTxContextNamed(ctx, internal.CallerMethodName(1), f)
+}
+
+// NoTxContextNamed is like NoTxContext, but uses given method name
+// instead of caller's one.
+func (db *SQL) NoTxContextNamed(ctx context.Context, methodName string, f func(context.Context, *sqlx.DB) error) (err error) {
+	return db.run(methodName, func(c *call) error {
+		c.rows = &rowsCounter{}
+		return f(withDeadlineTimeouts(withRowsCounter(ctx, c.rows), c.pool.deadlineTimeouts), c.pool.conn)
+	})
 }
 
 // Tx provides DAL method wrapper with:
 // - converting sqlx errors which are actually bugs into panics,
 // - general metrics for DAL methods,
-// - wrapping errors with DAL method name,
-// - transaction.
+// - wrapping errors into *DALError with DAL method name,
+// - classifying driver errors (see dberr package),
+// - handling panics according to SQLConfig.PanicPolicy,
+// - transaction,
+// - timeouts from ctx deadline (see SQLConfig.DeadlineTimeouts),
+// - recording writes for ctx created by WithReadYourWrites (see SQLConfig.WriteTracking),
+// - calling funcs registered by OnCommit after commit.
 func (db *SQL) Tx(ctx context.Context, opts *sql.TxOptions, f func(*sqlx.Tx) error) (err error) {
-	methodName := internal.CallerMethodName(1)
-	return db.metrics.Collecting(methodName, func() error {
-		tx, err := db.conn.BeginTxx(ctx, opts)
-		if err == nil { //nolint:nestif // No idea how to simplify.
-			defer func() {
-				if err := recover(); err != nil {
-					if errRollback := tx.Rollback(); errRollback != nil {
-						err = fmt.Errorf("%v: %s", err, errRollback)
-					}
-					panic(err)
+	return db.TxNamed(ctx, internal.CallerMethodName(1), opts, f)
+}
+
+// TxNamed is like Tx, but uses given method name instead of caller's one.
+// It's useful for closures and functions which aren't DAL methods.
+func (db *SQL) TxNamed(ctx context.Context, methodName string, opts *sql.TxOptions, f func(*sqlx.Tx) error) (err error) {
+	return db.run(methodName, func(c *call) error {
+		c.phase = PhaseBegin
+		c.rows = &rowsCounter{}
+		start := time.Now()
+		c.pool.waiting.Add(1)
+		tx, err := c.pool.conn.BeginTxx(withDeadlineTimeouts(withRowsCounter(ctx, c.rows), c.pool.deadlineTimeouts), opts)
+		c.pool.waiting.Add(-1)
+		db.txMetrics.TxPhase(methodName, PhaseBegin, time.Since(start))
+		if err != nil {
+			return err
+		}
+		committed := false
+		done := trackCommit(tx)
+		defer func() { done(committed) }()
+		defer func() {
+			if p := recover(); p != nil {
+				db.txMetrics.TxPhase(methodName, PhaseExec, time.Since(start))
+				c.errRollback = db.rollback(methodName, tx)
+				if c.errRollback != nil {
+					c.phase = PhaseRollback
 				}
-			}()
-			err = f(tx)
-			if err == nil {
-				err = tx.Commit()
-			} else if errRollback := tx.Rollback(); errRollback != nil {
-				err = fmt.Errorf("%w: %s", err, errRollback)
+				db.txMetrics.TxOutcome(methodName, TxPanicked)
+				panic(p)
+			}
+		}()
+
+		c.phase = PhaseExec
+		start = time.Now()
+		err = f(tx)
+		db.txMetrics.TxPhase(methodName, PhaseExec, time.Since(start))
+		if err != nil {
+			c.errRollback = db.rollback(methodName, tx)
+			if c.errRollback != nil {
+				db.txMetrics.TxOutcome(methodName, TxRollbackFailed)
+			} else {
+				db.txMetrics.TxOutcome(methodName, TxRolledBack)
+			}
+			return err
+		}
+
+		c.phase = PhaseCommit
+		start = time.Now()
+		err = tx.Commit()
+		db.txMetrics.TxPhase(methodName, PhaseCommit, time.Since(start))
+		if err != nil {
+			// Failed commit means transaction is rolled back by server.
+			db.txMetrics.TxOutcome(methodName, TxRolledBack)
+		} else {
+			db.txMetrics.TxOutcome(methodName, TxCommitted)
+			if opts == nil || !opts.ReadOnly {
+				db.recordWrite(ctx, methodName, c.pool.conn)
 			}
+			committed = true
+		}
+		return err
+	})
+}
+
+func (db *SQL) rollback(methodName string, tx *sqlx.Tx) error {
+	start := time.Now()
+	err := tx.Rollback()
+	db.txMetrics.TxPhase(methodName, PhaseRollback, time.Since(start))
+	return err
+}
+
+// Conn provides DAL method wrapper with dedicated connection, which is
+// required for session bound things like advisory locks:
+// - general metrics for DAL methods,
+// - wrapping errors into *DALError with DAL method name,
+// - classifying driver errors (see dberr package),
+// - handling panics according to SQLConfig.PanicPolicy.
+// Connection is returned to pool when f returns, so f must release all
+// session state (locks, variables) before that.
+// Use Raw instead of sqlx.Conn.Raw to access connection of driver.
+func (db *SQL) Conn(ctx context.Context, f func(*sqlx.Conn) error) (err error) {
+	return db.ConnNamed(ctx, internal.CallerMethodName(1), f)
+}
+
+// ConnNamed is like Conn, but uses given method name instead of caller's one.
+func (db *SQL) ConnNamed(ctx context.Context, methodName string, f func(*sqlx.Conn) error) (err error) {
+	return db.run(methodName, func(c *call) error {
+		c.phase = PhaseBegin
+		c.pool.waiting.Add(1)
+		conn, err := c.pool.conn.Connx(ctx)
+		c.pool.waiting.Add(-1)
+		if err != nil {
+			return err
 		}
+		defer conn.Close() //nolint:errcheck // Returns connection to pool on panic, no-op otherwise.
+
+		c.rows = &rowsCounter{}
+		err = conn.Raw(func(driverConn interface{}) error {
+			driverConn.(*wrappedConn).counter.Store(c.rows)
+			return nil
+		})
 		if err != nil {
-			err = fmt.Errorf("%s: %w", methodName, err)
+			return err
+		}
+
+		c.phase = PhaseExec
+		err = f(conn)
+		if errClose := conn.Close(); err == nil {
+			err = errClose
 		}
 		return err
+	})
+}
+
+// Raw is like sqlx.Conn.Raw, but f receives connection of driver
+// (e.g. *stdlib.Conn of pgx) instead of connection wrapped by SQL.
+func Raw(conn *sqlx.Conn, f func(driverConn interface{}) error) error {
+	return conn.Raw(func(driverConn interface{}) error {
+		if wrapped, ok := driverConn.(interface{ Unwrap() driver.Conn }); ok {
+			driverConn = wrapped.Unwrap()
+		}
+		return f(driverConn)
+	})
+}
+
+// call is a state of DAL method call.
+type call struct {
+	method      string
+	start       time.Time
+	phase       Phase
+	errRollback error
+	// rows is nil if call can't count rows.
+	rows *rowsCounter
+	// pool must be used for all statements of call.
+	pool *pool
+}
+
+// run is a common part of all DAL method wrappers: it collects metrics,
+// wraps errors into *DALError and handles panics.
+func (db *SQL) run(methodName string, f func(*call) error) error {
+	return db.metrics.Collecting(methodName, func() (err error) {
+		c := &call{method: methodName, start: time.Now(), phase: PhaseExec, pool: db.acquire()}
+		defer c.pool.release()
+		defer func() {
+			if p := recover(); p != nil {
+				err = db.recovered(c, p)
+			}
+		}()
+
+		err = db.shed(c)
+		if err != nil {
+			return newDALError(c.method, PhaseAdmit, c.start, err, nil)
+		}
+
+		err = f(c)
+		if c.rows != nil {
+			db.rowsMetrics.Rows(c.method, c.rows.returned.Load(), c.rows.affected.Load())
+		}
+		return newDALError(c.method, c.phase, c.start, err, c.errRollback)
 	})()
 }
+
+// recovered handles panic according to panic policy.
+func (db *SQL) recovered(c *call, p interface{}) error {
+	errPanic := &PanicError{Value: p, Stack: debug.Stack()}
+
+	switch db.panicPolicy {
+	case PanicToError:
+		return newDALError(c.method, c.phase, c.start, errPanic, c.errRollback)
+	case PanicReport:
+		db.onPanic(c.method, errPanic)
+	}
+
+	if c.errRollback != nil {
+		panic(newDALError(c.method, c.phase, c.start, errPanic, c.errRollback))
+	}
+	panic(p)
+}
@@ -0,0 +1,206 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/connectors"
+)
+
+// start returns SQL client connected to new sqlmock database.
+func start(t *testing.T, cfg database.SQLConfig) (*database.SQL, sqlmock.Sqlmock) {
+	t.Helper()
+	r := require.New(t)
+
+	dsn := t.Name() + time.Now().String()
+	_, mock, err := sqlmock.NewWithDSN(dsn)
+	r.NoError(err)
+
+	db, err := database.NewSQL(context.Background(), "sqlmock", cfg, &connectors.Raw{Query: dsn})
+	r.NoError(err)
+
+	return db, mock
+}
+
+// metricValue returns value of counter, gauge or sample count of histogram with given labels.
+func metricValue(t *testing.T, reg prometheus.Gatherer, name string, labels prometheus.Labels) float64 {
+	t.Helper()
+	r := require.New(t)
+
+	families, err := reg.Gather()
+	r.NoError(err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if value, ok := labels[label.GetName()]; ok && value != label.GetValue() {
+					continue metrics
+				}
+			}
+			if metric.GetHistogram() != nil {
+				return float64(metric.GetHistogram().GetSampleCount())
+			}
+			if metric.GetGauge() != nil {
+				return metric.GetGauge().GetValue()
+			}
+			return metric.GetCounter().GetValue()
+		}
+	}
+
+	return 0
+}
+
+// histogramSum returns sum of histogram with given labels.
+func histogramSum(t *testing.T, reg prometheus.Gatherer, name string, labels prometheus.Labels) float64 {
+	t.Helper()
+	r := require.New(t)
+
+	families, err := reg.Gather()
+	r.NoError(err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if value, ok := labels[label.GetName()]; ok && value != label.GetValue() {
+					continue metrics
+				}
+			}
+			return metric.GetHistogram().GetSampleSum()
+		}
+	}
+
+	return 0
+}
+
+// repo is an example of DAL with methods named as in metrics.
+type repo struct {
+	db *database.SQL
+}
+
+func (r repo) Exec(query string) error {
+	return r.db.NoTx(func(db *sqlx.DB) error {
+		_, err := db.Exec(query)
+		return err
+	})
+}
+
+func (r repo) TxExec(ctx context.Context, query string) error {
+	return r.db.Tx(ctx, nil, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, query)
+		return err
+	})
+}
+
+func (r repo) TxRows(ctx context.Context) error {
+	return r.db.Tx(ctx, nil, func(tx *sqlx.Tx) error {
+		var ids []int
+		err := tx.SelectContext(ctx, &ids, "select")
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "update")
+		return err
+	})
+}
+
+func (r repo) NoTxRows(ctx context.Context) error {
+	return r.db.NoTxContext(ctx, func(ctx context.Context, db *sqlx.DB) error {
+		var ids []int
+		err := db.SelectContext(ctx, &ids, "select")
+		if err != nil {
+			return err
+		}
+		_, err = db.ExecContext(ctx, "update")
+		return err
+	})
+}
+
+func (r repo) ConnRows(ctx context.Context) error {
+	return r.db.Conn(ctx, func(conn *sqlx.Conn) error {
+		var ids []int
+		err := conn.SelectContext(ctx, &ids, "select")
+		if err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx, "update")
+		return err
+	})
+}
+
+func (r repo) TxPanic(ctx context.Context) error {
+	return r.db.Tx(ctx, nil, func(*sqlx.Tx) error {
+		panic("boom")
+	})
+}
+
+func (r repo) Panic() error {
+	return r.db.NoTx(func(*sqlx.DB) error {
+		panic("boom")
+	})
+}
+
+// txExec is a function, not a DAL method.
+func txExec(ctx context.Context, db *database.SQL, query string) error {
+	return db.Tx(ctx, nil, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, query)
+		return err
+	})
+}
+
+// routerRepo is an example of DAL using Router.
+type routerRepo struct {
+	db *database.Router
+}
+
+func (r routerRepo) Get(ctx context.Context) (ids []int, err error) {
+	err = r.db.ReadNoTx(ctx, func(ctx context.Context, db *sqlx.DB) error {
+		return db.SelectContext(ctx, &ids, "select")
+	})
+	return ids, err
+}
+
+func (r routerRepo) GetTx(ctx context.Context) (ids []int, err error) {
+	err = r.db.ReadTx(ctx, func(tx *sqlx.Tx) error {
+		return tx.SelectContext(ctx, &ids, "select")
+	})
+	return ids, err
+}
+
+func (r routerRepo) Update(ctx context.Context) error {
+	return r.db.Tx(ctx, nil, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "update")
+		return err
+	})
+}
+
+// shardedRepo is an example of DAL using ShardedSQL.
+type shardedRepo struct {
+	db *database.ShardedSQL
+}
+
+func (r shardedRepo) Update(ctx context.Context, tenant string) error {
+	return r.db.Tx(ctx, tenant, nil, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "update")
+		return err
+	})
+}
+
+func (r shardedRepo) List(ctx context.Context) ([]int, error) {
+	return database.Gather(ctx, r.db, func(ctx context.Context, db *sqlx.DB) (ids []int, err error) {
+		err = db.SelectContext(ctx, &ids, "select")
+		return ids, err
+	})
+}
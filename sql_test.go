@@ -0,0 +1,372 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/connectors"
+	"github.com/sipki-tech/database/dberr"
+)
+
+func TestSQL_NoTx(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	db, mock := start(t, database.SQLConfig{})
+	errUnique := &pq.Error{Code: "23505", Constraint: "users_email_key"}
+	mock.ExpectExec("insert").WillReturnError(errUnique)
+
+	err := repo{db}.Exec("insert")
+	r.ErrorIs(err, errUnique)
+	r.ErrorIs(err, dberr.ErrUniqueViolation)
+	r.EqualError(err, "Exec: "+errUnique.Error())
+
+	dalErr := &database.DALError{}
+	r.ErrorAs(err, &dalErr)
+	r.Equal("Exec", dalErr.Method)
+	r.Equal(database.PhaseExec, dalErr.Phase)
+	r.Nil(dalErr.RollbackErr)
+	r.NoError(mock.ExpectationsWereMet())
+}
+
+func TestSQL_Tx(t *testing.T) {
+	t.Parallel()
+
+	errAny := errors.New("any error")
+	errRollback := errors.New("rollback error")
+
+	testCases := map[string]struct {
+		expect          func(sqlmock.Sqlmock)
+		wantPhase       database.Phase
+		wantErr         error
+		wantRollbackErr error
+		wantOutcome     database.TxOutcome
+	}{
+		"success": {func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}, 0, nil, nil, database.TxCommitted},
+		"begin": {func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin().WillReturnError(errAny)
+		}, database.PhaseBegin, errAny, nil, 0},
+		"exec": {func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec("update").WillReturnError(errAny)
+			mock.ExpectRollback()
+		}, database.PhaseExec, errAny, nil, database.TxRolledBack},
+		"exec_and_rollback": {func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec("update").WillReturnError(errAny)
+			mock.ExpectRollback().WillReturnError(errRollback)
+		}, database.PhaseExec, errAny, errRollback, database.TxRollbackFailed},
+		"commit": {func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit().WillReturnError(errAny)
+		}, database.PhaseCommit, errAny, nil, database.TxRolledBack},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			reg := prometheus.NewPedanticRegistry()
+			db, mock := start(t, database.SQLConfig{
+				Metrics: database.NewMetrics(reg, "test", "db", new(interface{ TxExec() })),
+			})
+			tc.expect(mock)
+
+			err := repo{db}.TxExec(context.Background(), "update")
+			r.NoError(mock.ExpectationsWereMet())
+
+			beginCount := metricValue(t, reg, "test_db_tx_phase_duration_seconds", prometheus.Labels{"func": "TxExec", "phase": "begin"})
+			r.Equal(1.0, beginCount)
+			if tc.wantOutcome != 0 {
+				outcomes := metricValue(t, reg, "test_db_tx_total", prometheus.Labels{"func": "TxExec", "outcome": tc.wantOutcome.String()})
+				r.Equal(1.0, outcomes)
+			}
+			if tc.wantErr == nil {
+				r.NoError(err)
+				return
+			}
+
+			r.ErrorIs(err, tc.wantErr)
+			dalErr := &database.DALError{}
+			r.ErrorAs(err, &dalErr)
+			r.Equal("TxExec", dalErr.Method)
+			r.Equal(tc.wantPhase, dalErr.Phase)
+			r.ErrorIs(dalErr.Err, tc.wantErr)
+			if tc.wantRollbackErr != nil {
+				r.ErrorIs(err, tc.wantRollbackErr)
+				r.ErrorIs(dalErr.RollbackErr, tc.wantRollbackErr)
+			} else {
+				r.Nil(dalErr.RollbackErr)
+			}
+		})
+	}
+}
+
+func TestSQL_Rows(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		method string
+		call   func(repo, context.Context) error
+		tx     bool
+	}{
+		"tx":    {"TxRows", repo.TxRows, true},
+		"no_tx": {"NoTxRows", repo.NoTxRows, false},
+		"conn":  {"ConnRows", repo.ConnRows, false},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			reg := prometheus.NewPedanticRegistry()
+			db, mock := start(t, database.SQLConfig{
+				Metrics: database.NewMetrics(reg, "test", "db", nil),
+			})
+			if tc.tx {
+				mock.ExpectBegin()
+			}
+			mock.ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3))
+			mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 5))
+			if tc.tx {
+				mock.ExpectCommit()
+			}
+
+			err := tc.call(repo{db}, context.Background())
+			r.NoError(err)
+			r.NoError(mock.ExpectationsWereMet())
+
+			labels := prometheus.Labels{"func": tc.method}
+			r.Equal(3.0, histogramSum(t, reg, "test_db_rows_returned", labels))
+			r.Equal(5.0, histogramSum(t, reg, "test_db_rows_affected", labels))
+		})
+	}
+}
+
+func TestSQL_RowsNoTx(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	reg := prometheus.NewPedanticRegistry()
+	db, mock := start(t, database.SQLConfig{
+		Metrics: database.NewMetrics(reg, "test", "db", nil),
+	})
+	mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 5))
+
+	r.NoError(repo{db}.Exec("update"))
+	r.NoError(mock.ExpectationsWereMet())
+	r.Equal(1.0, metricValue(t, reg, "test_db_call_duration_seconds", prometheus.Labels{"func": "Exec"}))
+	r.Equal(0.0, metricValue(t, reg, "test_db_rows_affected", prometheus.Labels{"func": "Exec"}))
+}
+
+func TestSQL_Named(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	reg := prometheus.NewPedanticRegistry()
+	db, mock := start(t, database.SQLConfig{
+		Metrics: database.NewMetrics(reg, "test", "db", nil),
+	})
+	mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r.NoError(db.NoTxNamed("Update", func(db *sqlx.DB) error {
+		_, err := db.ExecContext(ctx, "update")
+		return err
+	}))
+	r.NoError(db.TxNamed(ctx, "TxUpdate", nil, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "update")
+		return err
+	}))
+	r.NoError(txExec(ctx, db, "update"))
+	r.NoError(db.NoTxContextNamed(ctx, "CtxUpdate", func(ctx context.Context, db *sqlx.DB) error {
+		_, err := db.ExecContext(ctx, "update")
+		return err
+	}))
+	r.NoError(db.ConnNamed(ctx, "ConnUpdate", func(conn *sqlx.Conn) error {
+		_, err := conn.ExecContext(ctx, "update")
+		return err
+	}))
+	r.NoError(mock.ExpectationsWereMet())
+
+	for _, method := range []string{"Update", "TxUpdate", "txExec", "CtxUpdate", "ConnUpdate"} {
+		count := metricValue(t, reg, "test_db_call_duration_seconds", prometheus.Labels{"func": method})
+		r.Equal(1.0, count, method)
+	}
+}
+
+func TestSQL_SessionInit(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	dsn := t.Name() + time.Now().String()
+	_, mock, err := sqlmock.NewWithDSN(dsn)
+	r.NoError(err)
+	mock.ExpectExec("SET TIME ZONE 'UTC'").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SET application_name = \\$1").WithArgs("test").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	reg := prometheus.NewPedanticRegistry()
+	db, err := database.NewSQL(context.Background(), "sqlmock", database.SQLConfig{
+		Metrics:     database.NewMetrics(reg, "test", "db"),
+		SessionInit: []string{"SET TIME ZONE 'UTC'"},
+		OnConnect: func(ctx context.Context, session database.Session) error {
+			return session.Exec(ctx, "SET application_name = $1", "test")
+		},
+	}, &connectors.Raw{Query: dsn})
+	r.NoError(err)
+	r.NoError(mock.ExpectationsWereMet())
+	r.Equal(1.0, metricValue(t, reg, "test_db_conn_init_total", nil))
+
+	mock.ExpectClose()
+	r.NoError(db.Close())
+}
+
+func TestSQL_SessionInitErr(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	dsn := t.Name() + time.Now().String()
+	_, mock, err := sqlmock.NewWithDSN(dsn)
+	r.NoError(err)
+	mock.ExpectExec("SET statement_timeout").WillReturnError(&pq.Error{Code: "42501"})
+
+	reg := prometheus.NewPedanticRegistry()
+	_, err = database.NewSQL(context.Background(), "sqlmock", database.SQLConfig{
+		Metrics:     database.NewMetrics(reg, "test", "db"),
+		SessionInit: []string{"SET statement_timeout = '5s'"},
+	}, &connectors.Raw{Query: dsn})
+	r.ErrorIs(err, database.ErrSessionInit)
+	r.ErrorIs(err, dberr.ErrInsufficientPrivilege)
+	r.NoError(mock.ExpectationsWereMet())
+	r.Equal(1.0, metricValue(t, reg, "test_db_conn_init_errors_total", prometheus.Labels{"class": "insufficient_privilege"}))
+}
+
+func TestSQL_TxPanic(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	db, mock := start(t, database.SQLConfig{})
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	r.PanicsWithValue("boom", func() { _ = repo{db}.TxPanic(context.Background()) })
+	r.NoError(mock.ExpectationsWereMet())
+}
+
+func TestRaw(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	db, mock := start(t, database.SQLConfig{})
+	err := db.Conn(context.Background(), func(conn *sqlx.Conn) error {
+		return database.Raw(conn, func(driverConn interface{}) error {
+			r.Equal(mock, driverConn)
+			return nil
+		})
+	})
+	r.NoError(err)
+	r.NoError(mock.ExpectationsWereMet())
+}
+
+func TestDALError_LogValue(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	err := &database.DALError{
+		Method:      "Method",
+		Phase:       database.PhaseExec,
+		Err:         errors.New("error"),
+		RollbackErr: errors.New("rollback error"),
+	}
+	r.Equal("[method=Method phase=exec duration=0s error=error rollback_error=rollback error]", err.LogValue().String())
+	r.EqualError(err, "Method: error: rollback error")
+}
+
+func TestSQL_PanicPolicy(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		policy    database.PanicPolicy
+		wantPanic bool
+		wantCalls int
+	}{
+		"default": {0, true, 0},
+		"repanic": {database.PanicRepanic, true, 0},
+		"error":   {database.PanicToError, false, 0},
+		"report":  {database.PanicReport, true, 1},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			reg := prometheus.NewPedanticRegistry()
+			metrics := database.NewMetrics(reg, "test", "db", new(interface{ Panic() error }))
+			calls := 0
+			db, mock := start(t, database.SQLConfig{
+				Metrics:     metrics,
+				PanicPolicy: tc.policy,
+				OnPanic: func(method string, err *database.PanicError) {
+					calls++
+					r.Equal("Panic", method)
+					r.Equal("boom", err.Value)
+					r.NotEmpty(err.Stack)
+				},
+			})
+
+			var err error
+			if tc.wantPanic {
+				r.PanicsWithValue("boom", func() { err = repo{db}.Panic() })
+			} else {
+				r.NotPanics(func() { err = repo{db}.Panic() })
+				errPanic := &database.PanicError{}
+				r.ErrorAs(err, &errPanic)
+				r.Equal("boom", errPanic.Value)
+				r.Contains(string(errPanic.Stack), "repo.Panic")
+				r.EqualError(err, "Panic: panic: boom")
+			}
+			r.Equal(tc.wantCalls, calls)
+			r.NoError(mock.ExpectationsWereMet())
+
+			const want = `
+# HELP test_db_errors_total Amount of DAL errors.
+# TYPE test_db_errors_total counter
+test_db_errors_total{func="Panic"} 0
+# HELP test_db_panics_total Amount of DAL panics.
+# TYPE test_db_panics_total counter
+test_db_panics_total{func="Panic"} 1
+`
+			r.NoError(testutil.GatherAndCompare(reg, strings.NewReader(want), "test_db_errors_total", "test_db_panics_total"))
+		})
+	}
+}
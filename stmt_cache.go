@@ -0,0 +1,170 @@
+package database
+
+import (
+	"container/list"
+	"context"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/sipki-tech/database/dberr"
+)
+
+// DefaultStatementCacheSize is a default value of SQLConfig.StatementCacheSize.
+const DefaultStatementCacheSize = 100
+
+// PreparedStatements defines usage of server-side prepared statements.
+type PreparedStatements uint8
+
+// Enum.
+const (
+	_ PreparedStatements = iota
+	// PreparedDriver leaves prepared statements to driver.
+	PreparedDriver // driver
+	// PreparedCache prepares every statement once per connection and keeps
+	// least recently used ones. Statements invalidated by schema changes
+	// are prepared again. Driver's own statement cache should be disabled
+	// (e.g. default_query_exec_mode=exec for pgx).
+	PreparedCache // cache
+	// PreparedDisabled never prepares named statements explicitly, it's
+	// required for poolers like PgBouncer in transaction pooling mode.
+	// Driver must implement driver.ExecerContext and driver.QueryerContext,
+	// otherwise NewSQL returns ErrPreparedUnsupported.
+	// Driver must be configured to not prepare statements too
+	// (e.g. default_query_exec_mode=exec for pgx).
+	PreparedDisabled // disabled
+)
+
+// ErrPreparedUnsupported is returned if PreparedDisabled is used with
+// driver which can't execute statements without preparing them.
+var ErrPreparedUnsupported = errors.New("driver requires prepared statements")
+
+// stmtCache is LRU of statements prepared on one connection.
+// Connection is used by one goroutine at a time, so it isn't thread-safe.
+type stmtCache struct {
+	size  int
+	stmts map[string]*list.Element // Value: *cachedStmt.
+	lru   *list.List               // Front is most recently used.
+}
+
+type cachedStmt struct {
+	query string
+	stmt  driver.Stmt
+}
+
+func newStmtCache(size int) *stmtCache {
+	return &stmtCache{
+		size:  size,
+		stmts: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+// prepare returns cached statement for query or prepares new one.
+func (c *stmtCache) prepare(ctx context.Context, conn *wrappedConn, query string) (driver.Stmt, error) {
+	if elem, ok := c.stmts[query]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*cachedStmt).stmt, nil
+	}
+
+	stmt, err := conn.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[query] = c.lru.PushFront(&cachedStmt{query: query, stmt: stmt})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back().Value.(*cachedStmt).query)
+	}
+	return stmt, nil
+}
+
+// remove closes and removes statement for query.
+func (c *stmtCache) remove(query string) {
+	elem, ok := c.stmts[query]
+	if !ok {
+		return
+	}
+	c.lru.Remove(elem)
+	delete(c.stmts, query)
+	_ = elem.Value.(*cachedStmt).stmt.Close()
+}
+
+// execCached executes query using cached statement, statement invalidated
+// by schema change is prepared again unless connection is in transaction,
+// which is aborted by error anyway.
+func (c *wrappedConn) execCached(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	for retry := true; ; retry = false {
+		stmt, err := c.stmts.prepare(ctx, c, query)
+		if err != nil {
+			return nil, err
+		}
+		res, err := execStmt(ctx, stmt, args)
+		if c.invalidated(query, err) && retry {
+			continue
+		}
+		return res, err
+	}
+}
+
+// queryCached is like execCached for queries.
+func (c *wrappedConn) queryCached(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	for retry := true; ; retry = false {
+		stmt, err := c.stmts.prepare(ctx, c, query)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := queryStmt(ctx, stmt, args)
+		if c.invalidated(query, err) && retry {
+			continue
+		}
+		return rows, err
+	}
+}
+
+// invalidated removes statement for query from cache if err means it's
+// invalidated and returns true if it may be prepared again.
+func (c *wrappedConn) invalidated(query string, err error) bool {
+	if !errors.Is(dberr.Classify(err), dberr.ErrStaleStatement) {
+		return false
+	}
+	c.stmts.remove(query)
+	return !c.inTx
+}
+
+// unpreparedStmt executes query without prepared statement, it's returned
+// by wrappedConn.PrepareContext if prepared statements are disabled.
+type unpreparedStmt struct {
+	conn  *wrappedConn
+	query string
+}
+
+var (
+	_ driver.Stmt             = (*unpreparedStmt)(nil)
+	_ driver.StmtExecContext  = (*unpreparedStmt)(nil)
+	_ driver.StmtQueryContext = (*unpreparedStmt)(nil)
+)
+
+// Close implements driver.Stmt.
+func (*unpreparedStmt) Close() error { return nil }
+
+// NumInput implements driver.Stmt.
+func (*unpreparedStmt) NumInput() int { return -1 }
+
+// Exec implements driver.Stmt.
+func (s *unpreparedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), valuesToNamedValues(args))
+}
+
+// Query implements driver.Stmt.
+func (s *unpreparedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), valuesToNamedValues(args))
+}
+
+// ExecContext implements driver.StmtExecContext.
+func (s *unpreparedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.Conn.(driver.ExecerContext).ExecContext(ctx, s.query, args)
+}
+
+// QueryContext implements driver.StmtQueryContext.
+func (s *unpreparedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.Conn.(driver.QueryerContext).QueryContext(ctx, s.query, args)
+}
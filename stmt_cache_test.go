@@ -0,0 +1,128 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/connectors"
+)
+
+// prepareOnlyDriver can't execute statements without preparing them.
+type prepareOnlyDriver struct{}
+
+func (prepareOnlyDriver) Open(string) (driver.Conn, error) { return prepareOnlyConn{}, nil }
+
+type prepareOnlyConn struct{}
+
+func (prepareOnlyConn) Prepare(string) (driver.Stmt, error) { return nil, errors.ErrUnsupported }
+func (prepareOnlyConn) Close() error                        { return nil }
+func (prepareOnlyConn) Begin() (driver.Tx, error)           { return nil, errors.ErrUnsupported }
+
+func init() { sql.Register("prepareonly", prepareOnlyDriver{}) }
+
+func TestNewSQL_PreparedUnsupported(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	_, err := database.NewSQL(context.Background(), "prepareonly", database.SQLConfig{
+		PreparedStatements: database.PreparedDisabled,
+	}, &connectors.Raw{Query: "dsn"})
+	r.ErrorIs(err, database.ErrPreparedUnsupported)
+
+	db, err := database.NewSQL(context.Background(), "prepareonly", database.SQLConfig{}, &connectors.Raw{Query: "dsn"})
+	r.NoError(err)
+	r.NoError(db.Close())
+}
+
+func TestSQL_PreparedStatements(t *testing.T) {
+	t.Parallel()
+
+	errStale := &pq.Error{Code: "0A000", Message: "cached plan must not change result type", Routine: "RevalidateCachedQuery"}
+	exec := func(db *database.SQL, query string) error {
+		return db.NoTxContext(context.Background(), func(ctx context.Context, db *sqlx.DB) error {
+			_, err := db.ExecContext(ctx, query, 1)
+			return err
+		})
+	}
+
+	t.Run("cache", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		db, mock := start(t, database.SQLConfig{PreparedStatements: database.PreparedCache})
+		mock.ExpectPrepare("update").ExpectExec().WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("update").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		// Stale statement is prepared again.
+		mock.ExpectExec("update").WithArgs(1).WillReturnError(errStale)
+		mock.ExpectPrepare("update").ExpectExec().WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		for i := 0; i < 3; i++ {
+			r.NoError(exec(db, "update"))
+		}
+		r.NoError(mock.ExpectationsWereMet())
+	})
+
+	t.Run("evict", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		db, mock := start(t, database.SQLConfig{PreparedStatements: database.PreparedCache, StatementCacheSize: 1})
+		mock.ExpectPrepare("update").ExpectExec().WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectPrepare("delete").ExpectExec().WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectPrepare("update").ExpectExec().WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		r.NoError(exec(db, "update"))
+		r.NoError(exec(db, "delete"))
+		r.NoError(exec(db, "update"))
+		r.NoError(mock.ExpectationsWereMet())
+	})
+
+	t.Run("stale_in_tx", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+		ctx := context.Background()
+
+		db, mock := start(t, database.SQLConfig{PreparedStatements: database.PreparedCache})
+		mock.ExpectBegin()
+		mock.ExpectPrepare("update").ExpectExec().WithArgs(1).WillReturnError(errStale)
+		mock.ExpectRollback()
+
+		err := db.Tx(ctx, nil, func(tx *sqlx.Tx) error {
+			_, err := tx.ExecContext(ctx, "update", 1)
+			return err
+		})
+		r.ErrorIs(err, errStale)
+		r.NoError(mock.ExpectationsWereMet())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+		ctx := context.Background()
+
+		db, mock := start(t, database.SQLConfig{PreparedStatements: database.PreparedDisabled})
+		mock.ExpectExec("update").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := db.NoTxContext(ctx, func(ctx context.Context, db *sqlx.DB) error {
+			stmt, err := db.PreparexContext(ctx, "update")
+			if err != nil {
+				return err
+			}
+			defer stmt.Close() //nolint:errcheck // Nothing to do with error.
+
+			_, err = stmt.ExecContext(ctx, 1)
+			return err
+		})
+		r.NoError(err)
+		r.NoError(mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/sipki-tech/database/internal"
+)
+
+// DefaultTenantSetting is a setting used by RLS policies, e.g.
+// current_setting('app.tenant_id').
+const DefaultTenantSetting = "app.tenant_id"
+
+// ErrNoTenant is returned by tenant scoped DAL methods if context has no tenant.
+var ErrNoTenant = errors.New("no tenant in context")
+
+type tenantKey struct{}
+
+// WithTenant returns ctx with tenant for tenant scoped DAL methods.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns tenant set by WithTenant.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+// SchemaTx is like SQL.Tx, but sets search_path to schema of tenant from ctx
+// (see SQLConfig.TenantSchema) for this transaction only.
+// It returns ErrNoTenant without starting transaction if ctx has no tenant.
+func (db *SQL) SchemaTx(ctx context.Context, opts *sql.TxOptions, f func(*sqlx.Tx) error) error {
+	methodName := internal.CallerMethodName(1)
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return db.noTenant(methodName)
+	}
+
+	schema := quoteIdentifier(db.tenantSchema(tenant))
+	return db.TxNamed(ctx, methodName, opts, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "select set_config('search_path', $1, true)", schema)
+		if err != nil {
+			return err
+		}
+		return f(tx)
+	})
+}
+
+// RLSTx is like SQL.Tx, but sets SQLConfig.TenantSetting to tenant from ctx
+// for this transaction only, so row-level security policies may use it.
+// It returns ErrNoTenant without starting transaction if ctx has no tenant.
+func (db *SQL) RLSTx(ctx context.Context, opts *sql.TxOptions, f func(*sqlx.Tx) error) error {
+	methodName := internal.CallerMethodName(1)
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return db.noTenant(methodName)
+	}
+
+	return db.TxNamed(ctx, methodName, opts, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "select set_config($1, $2, true)", db.tenantSetting, tenant)
+		if err != nil {
+			return err
+		}
+		return f(tx)
+	})
+}
+
+func (db *SQL) noTenant(methodName string) error {
+	return db.run(methodName, func(c *call) error {
+		c.phase = PhaseBegin
+		return ErrNoTenant
+	})
+}
+
+// quoteIdentifier quotes name to be used as SQL identifier.
+// Name is truncated at first NUL byte, PostgreSQL doesn't allow it anyway.
+func quoteIdentifier(name string) string {
+	if i := strings.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,295 @@
+package database
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Default values for tenant pools config.
+const (
+	DefaultMaxTenantPools    = 100
+	DefaultTenantIdleTimeout = time.Minute * 10
+)
+
+// ErrPoolsClosed is returned by TenantPools after Close.
+var ErrPoolsClosed = errors.New("tenant pools closed")
+
+// TenantPoolsConfig for set additional properties of TenantPools.
+type TenantPoolsConfig struct {
+	// SQL is a config for every pool, its SetMaxOpenConnections and
+	// SetMaxIdleConnections are overwritten to fit MaxOpenConnections.
+	SQL SQLConfig
+	// Metrics are created for every pool with additional "tenant" const
+	// label and unregistered when pool is evicted, SQL.Metrics is used
+	// as is if Metrics is nil.
+	Metrics *MetricsConfig
+	// MaxPools is a maximum amount of open pools, least recently used pool
+	// is closed to open new one.
+	MaxPools int
+	// MaxOpenConnections is a maximum amount of connections of all pools,
+	// by default MaxPools * DefaultSetMaxOpenConnections. It may be exceeded
+	// while evicted pools finish calls which are in progress.
+	MaxOpenConnections int
+	// IdleTimeout is a time after which unused pool is closed by Run.
+	IdleTimeout time.Duration
+	// OnError is called for errors of closing pools.
+	OnError func(tenant string, err error)
+}
+
+func (c TenantPoolsConfig) setDefault() TenantPoolsConfig {
+	if c.MaxPools == 0 {
+		c.MaxPools = DefaultMaxTenantPools
+	}
+	if c.MaxOpenConnections == 0 {
+		c.MaxOpenConnections = c.MaxPools * DefaultSetMaxOpenConnections
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = DefaultTenantIdleTimeout
+	}
+	if c.OnError == nil {
+		c.OnError = func(string, error) {}
+	}
+
+	c.SQL.SetMaxOpenConnections = c.MaxOpenConnections / c.MaxPools
+	if c.SQL.SetMaxOpenConnections < 1 {
+		c.SQL.SetMaxOpenConnections = 1
+	}
+	c.SQL.SetMaxIdleConnections = c.SQL.SetMaxOpenConnections
+	return c
+}
+
+// TenantPools manages pool per tenant for tenants with dedicated databases.
+// Pools are created lazily and closed when they are idle or when there
+// are too many of them.
+type TenantPools struct {
+	driver    string
+	cfg       TenantPoolsConfig
+	connector func(tenant string) (Connector, error)
+
+	mu     sync.Mutex
+	pools  map[string]*list.Element // Value: *tenantPool.
+	lru    *list.List               // Front is most recently used.
+	closed bool
+
+	closing sync.WaitGroup // Evicted pools which aren't closed yet.
+}
+
+type tenantPool struct {
+	tenant   string
+	ready    chan struct{} // Closed when db or err is set.
+	db       *SQL
+	metrics  *Metrics // Registered while pool is in LRU, nil if TenantPoolsConfig.Metrics is nil.
+	err      error
+	refs     int
+	lastUsed time.Time
+	evicted  bool
+}
+
+// NewTenantPools returns TenantPools which creates pools using
+// connector returned by given func for tenant.
+func NewTenantPools(driver string, cfg TenantPoolsConfig, connector func(tenant string) (Connector, error)) *TenantPools {
+	return &TenantPools{
+		driver:    driver,
+		cfg:       cfg.setDefault(),
+		connector: connector,
+		pools:     make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+// Do calls f with pool of tenant, pool isn't closed until f returns.
+func (p *TenantPools) Do(ctx context.Context, tenant string, f func(*SQL) error) error {
+	pool, err := p.acquire(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	defer p.release(pool)
+
+	return f(pool.db)
+}
+
+func (p *TenantPools) acquire(ctx context.Context, tenant string) (*tenantPool, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolsClosed
+	}
+
+	elem, ok := p.pools[tenant]
+	if ok {
+		p.lru.MoveToFront(elem)
+	} else {
+		elem = p.lru.PushFront(&tenantPool{tenant: tenant, ready: make(chan struct{}), metrics: p.newMetrics(tenant)})
+		p.pools[tenant] = elem
+		p.evictLocked(p.lru.Len() - p.cfg.MaxPools)
+	}
+	pool := elem.Value.(*tenantPool)
+	pool.refs++
+	p.mu.Unlock()
+
+	if !ok {
+		db, err := p.open(ctx, tenant, pool.metrics)
+		p.mu.Lock()
+		pool.db, pool.err = db, err
+		if err != nil && !pool.evicted {
+			// Don't keep failed pool, next call will try again.
+			p.removeLocked(pool)
+		}
+		close(pool.ready)
+		p.mu.Unlock()
+	}
+
+	select {
+	case <-pool.ready:
+	case <-ctx.Done():
+		p.release(pool)
+		return nil, ctx.Err()
+	}
+	if pool.err != nil {
+		p.release(pool)
+		return nil, pool.err
+	}
+	return pool, nil
+}
+
+func (p *TenantPools) open(ctx context.Context, tenant string, metrics *Metrics) (*SQL, error) {
+	connector, err := p.connector(tenant)
+	if err != nil {
+		return nil, fmt.Errorf("connector %s: %w", tenant, err)
+	}
+
+	cfg := p.cfg.SQL
+	if metrics != nil {
+		cfg.Metrics = *metrics
+	}
+
+	db, err := NewSQL(ctx, p.driver, cfg, connector)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQL %s: %w", tenant, err)
+	}
+	return db, nil
+}
+
+// newMetrics registers metrics for pool of tenant, it returns nil if
+// TenantPoolsConfig.Metrics is nil.
+func (p *TenantPools) newMetrics(tenant string) *Metrics {
+	if p.cfg.Metrics == nil {
+		return nil
+	}
+
+	cfg := *p.cfg.Metrics
+	cfg.ConstLabels = prometheus.Labels{labelTenant: tenant}
+	for name, value := range p.cfg.Metrics.ConstLabels {
+		cfg.ConstLabels[name] = value
+	}
+	metrics := NewMetricsWithConfig(cfg)
+	return &metrics
+}
+
+func (p *TenantPools) release(pool *tenantPool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pool.refs--
+	pool.lastUsed = time.Now()
+	if pool.evicted && pool.refs == 0 {
+		p.closeLocked(pool)
+	}
+}
+
+// evictLocked evicts n least recently used pools.
+func (p *TenantPools) evictLocked(n int) {
+	for ; n > 0; n-- {
+		p.removeLocked(p.lru.Back().Value.(*tenantPool))
+	}
+}
+
+// removeLocked removes pool from LRU, it's closed when it isn't used.
+// Metrics are unregistered immediately, so pool opened again for same
+// tenant registers its own ones.
+func (p *TenantPools) removeLocked(pool *tenantPool) {
+	p.lru.Remove(p.pools[pool.tenant])
+	delete(p.pools, pool.tenant)
+	pool.evicted = true
+	if pool.metrics != nil {
+		pool.metrics.Unregister(p.cfg.Metrics.setDefault().Registerer)
+	}
+	// Close must wait for pool even if it's closed later by release.
+	p.closing.Add(1)
+	if pool.refs == 0 {
+		p.closeLocked(pool)
+	}
+}
+
+// closeLocked closes evicted pool, it's called exactly once per pool.
+func (p *TenantPools) closeLocked(pool *tenantPool) {
+	if pool.db == nil {
+		p.closing.Done()
+		return
+	}
+	db := pool.db
+	pool.db = nil
+	go func() {
+		defer p.closing.Done()
+		err := db.Close()
+		if err != nil {
+			p.cfg.OnError(pool.tenant, err)
+		}
+	}()
+}
+
+// Len returns amount of open pools.
+func (p *TenantPools) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Len()
+}
+
+// Run closes idle pools until ctx is done.
+func (p *TenantPools) Run(ctx context.Context) error {
+	t := time.NewTicker(p.cfg.IdleTimeout / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+
+		p.evictIdle(time.Now().Add(-p.cfg.IdleTimeout))
+	}
+}
+
+// evictIdle evicts unused pools which were used last time before given time.
+func (p *TenantPools) evictIdle(before time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for elem := p.lru.Back(); elem != nil; {
+		pool := elem.Value.(*tenantPool)
+		elem = elem.Prev()
+		if pool.refs == 0 && pool.lastUsed.Before(before) {
+			p.removeLocked(pool)
+		}
+	}
+}
+
+// Close closes all pools and waits for them, pools which are in use are
+// closed after use, so Close must not be called inside Do.
+// Errors are reported to OnError.
+func (p *TenantPools) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.evictLocked(p.lru.Len())
+	p.mu.Unlock()
+
+	p.closing.Wait()
+	return nil
+}
@@ -0,0 +1,163 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/connectors"
+)
+
+func TestTenantPools(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	mocks := make(map[string]sqlmock.Sqlmock)
+	dsns := make(map[string]string)
+	for _, tenant := range []string{"a", "b", "c"} {
+		dsns[tenant] = t.Name() + tenant + time.Now().String()
+		_, mock, err := sqlmock.NewWithDSN(dsns[tenant])
+		r.NoError(err)
+		mocks[tenant] = mock
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	pools := database.NewTenantPools("sqlmock", database.TenantPoolsConfig{
+		Metrics:     &database.MetricsConfig{Registerer: reg, Namespace: "test", Subsystem: "db"},
+		MaxPools:    2,
+		IdleTimeout: 20 * time.Millisecond,
+	}, func(tenant string) (database.Connector, error) {
+		return &connectors.Raw{Query: dsns[tenant]}, nil
+	})
+	update := func(tenant string) error {
+		return pools.Do(ctx, tenant, func(db *database.SQL) error {
+			return db.NoTxNamed("Update", func(db *sqlx.DB) error {
+				_, err := db.ExecContext(ctx, "update")
+				return err
+			})
+		})
+	}
+
+	for _, tenant := range []string{"a", "b", "a", "c"} {
+		mocks[tenant].ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mocks["b"].ExpectClose()
+
+	r.NoError(update("a"))
+	r.NoError(update("b"))
+	r.NoError(update("a"))
+	r.NoError(update("c"))
+	r.Equal(2, pools.Len())
+	r.Equal(2.0, metricValue(t, reg, "test_db_call_duration_seconds", prometheus.Labels{"func": "Update", "tenant": "a"}))
+	r.Equal(1.0, metricValue(t, reg, "test_db_call_duration_seconds", prometheus.Labels{"func": "Update", "tenant": "c"}))
+
+	mocks["a"].ExpectClose()
+	mocks["c"].ExpectClose()
+	ctxRun, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	r.NoError(pools.Run(ctxRun))
+	r.Equal(0, pools.Len())
+
+	r.NoError(pools.Close())
+	r.ErrorIs(update("a"), database.ErrPoolsClosed)
+	for _, mock := range mocks {
+		r.NoError(mock.ExpectationsWereMet())
+	}
+	families, err := reg.Gather()
+	r.NoError(err)
+	r.Empty(families)
+}
+
+func TestTenantPools_Reacquire(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	mocks := make(map[string]sqlmock.Sqlmock)
+	dsns := make(map[string]string)
+	for _, tenant := range []string{"a", "b"} {
+		dsns[tenant] = t.Name() + tenant + time.Now().String()
+		_, mock, err := sqlmock.NewWithDSN(dsns[tenant])
+		r.NoError(err)
+		mocks[tenant] = mock
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	pools := database.NewTenantPools("sqlmock", database.TenantPoolsConfig{
+		Metrics:  &database.MetricsConfig{Registerer: reg, Namespace: "test", Subsystem: "db"},
+		MaxPools: 1,
+	}, func(tenant string) (database.Connector, error) {
+		return &connectors.Raw{Query: dsns[tenant]}, nil
+	})
+	update := func(tenant string) error {
+		return pools.Do(ctx, tenant, func(db *database.SQL) error {
+			return db.NoTxNamed("Update", func(db *sqlx.DB) error {
+				_, err := db.ExecContext(ctx, "update")
+				return err
+			})
+		})
+	}
+
+	// Evicted pool is closed in background.
+	mocks["a"].MatchExpectationsInOrder(false)
+	mocks["a"].ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	mocks["b"].ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	mocks["b"].ExpectClose()
+	mocks["a"].ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	mocks["a"].ExpectClose()
+	mocks["a"].ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+	mocks["a"].ExpectClose()
+
+	// Pool of "a" is evicted while it's in use and opened again.
+	r.NoError(update("a"))
+	r.NoError(pools.Do(ctx, "a", func(*database.SQL) error {
+		r.NoError(update("b"))
+		return update("a")
+	}))
+	r.NoError(update("a"))
+	r.Equal(2.0, metricValue(t, reg, "test_db_call_duration_seconds", prometheus.Labels{"func": "Update", "tenant": "a"}))
+
+	r.NoError(pools.Close())
+	for _, mock := range mocks {
+		r.NoError(mock.ExpectationsWereMet())
+	}
+}
+
+func TestTenantPools_CloseWaitsInUse(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+
+	dsn := t.Name() + time.Now().String()
+	_, mock, err := sqlmock.NewWithDSN(dsn)
+	r.NoError(err)
+	mock.ExpectClose()
+
+	pools := database.NewTenantPools("sqlmock", database.TenantPoolsConfig{}, func(string) (database.Connector, error) {
+		return &connectors.Raw{Query: dsn}, nil
+	})
+
+	closed := make(chan struct{})
+	err = pools.Do(ctx, "a", func(*database.SQL) error {
+		go func() {
+			defer close(closed)
+			r.NoError(pools.Close())
+		}()
+		select {
+		case <-closed:
+			t.Error("Close returned while pool is in use")
+		case <-time.After(50 * time.Millisecond):
+		}
+		return nil
+	})
+	r.NoError(err)
+	<-closed
+	r.NoError(mock.ExpectationsWereMet())
+}
@@ -0,0 +1,65 @@
+package database_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+)
+
+func TestSQL_TenantTx(t *testing.T) {
+	t.Parallel()
+
+	schemaTx := func(ctx context.Context, db *database.SQL) error {
+		return db.SchemaTx(ctx, nil, func(tx *sqlx.Tx) error {
+			_, err := tx.ExecContext(ctx, "update")
+			return err
+		})
+	}
+	rlsTx := func(ctx context.Context, db *database.SQL) error {
+		return db.RLSTx(ctx, nil, func(tx *sqlx.Tx) error {
+			_, err := tx.ExecContext(ctx, "update")
+			return err
+		})
+	}
+
+	testCases := map[string]struct {
+		call      func(context.Context, *database.SQL) error
+		tenant    string
+		wantQuery string
+		wantArgs  []driver.Value
+	}{
+		"schema":        {schemaTx, "acme", "select set_config('search_path', $1, true)", []driver.Value{`"tenant_acme"`}},
+		"schema_quoted": {schemaTx, "ac\"me\x00x", "select set_config('search_path', $1, true)", []driver.Value{`"tenant_ac""me"`}},
+		"rls":           {rlsTx, "acme", "select set_config($1, $2, true)", []driver.Value{"app.tenant", "acme"}},
+	}
+
+	for name, tc := range testCases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := require.New(t)
+
+			db, mock := start(t, database.SQLConfig{
+				TenantSchema:  func(tenant string) string { return "tenant_" + tenant },
+				TenantSetting: "app.tenant",
+			})
+
+			err := tc.call(context.Background(), db)
+			r.ErrorIs(err, database.ErrNoTenant)
+
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(tc.wantQuery)).WithArgs(tc.wantArgs...).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			r.NoError(tc.call(database.WithTenant(context.Background(), tc.tenant), db))
+			r.NoError(mock.ExpectationsWereMet())
+		})
+	}
+}
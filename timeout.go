@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DefaultDeadlineMargin is a default value of SQLConfig.DeadlineMargin.
+const DefaultDeadlineMargin = time.Millisecond * 50
+
+const (
+	setLocalTimeouts   = "select set_config('statement_timeout', $1, true), set_config('lock_timeout', $1, true)"
+	setSessionTimeouts = "select set_config('statement_timeout', $1, false), set_config('lock_timeout', $2, false)"
+	currentTimeouts    = "select current_setting('statement_timeout'), current_setting('lock_timeout')"
+)
+
+// ErrSessionTimeouts is returned if SQLConfig.NoTxDeadlineTimeouts is used
+// with PreparedDisabled. NoTxContext sets timeouts for session, with
+// transaction pooling they would leak to other clients of server connection.
+var ErrSessionTimeouts = errors.New("deadline timeouts require session pooling")
+
+// deadlineTimeouts makes connection set statement_timeout and lock_timeout
+// from deadline of context.
+type deadlineTimeouts struct {
+	margin time.Duration
+	// tx and noTx enable timeouts for transactions and NoTxContext.
+	tx, noTx bool
+}
+
+type deadlineTimeoutsKey struct{}
+
+// withDeadlineTimeouts returns ctx which makes connection set
+// statement_timeout and lock_timeout from its deadline, dt may be nil
+// to disable this.
+func withDeadlineTimeouts(ctx context.Context, dt *deadlineTimeouts) context.Context {
+	return context.WithValue(ctx, deadlineTimeoutsKey{}, dt)
+}
+
+// timeout returns value for statement_timeout and lock_timeout in
+// milliseconds or false if ctx has no deadline.
+func (dt *deadlineTimeouts) timeout(ctx context.Context) (string, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return "", false
+	}
+
+	ms := (time.Until(deadline) - dt.margin).Milliseconds()
+	if ms < 1 {
+		// Zero disables timeout, but client doesn't wait for statement anyway.
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10), true
+}
+
+// setDeadlineTimeouts sets statement_timeout and lock_timeout from ctx
+// deadline for current transaction if local, otherwise for session until
+// connection is returned to pool.
+func (c *wrappedConn) setDeadlineTimeouts(ctx context.Context, local bool) error {
+	dt, _ := ctx.Value(deadlineTimeoutsKey{}).(*deadlineTimeouts)
+	if dt == nil || local && !dt.tx || !local && !dt.noTx {
+		return nil
+	}
+	timeout, ok := dt.timeout(ctx)
+	if !ok {
+		return nil
+	}
+
+	if local {
+		_, err := c.queryRow(ctx, setLocalTimeouts, timeout)
+		return err
+	}
+
+	if c.savedTimeouts == nil {
+		row, err := c.queryRow(ctx, currentTimeouts)
+		if err != nil {
+			return err
+		}
+		c.savedTimeouts = []string{asString(row[0]), asString(row[1])}
+	}
+	_, err := c.queryRow(ctx, setSessionTimeouts, timeout, timeout)
+	return err
+}
+
+// restoreTimeouts restores session timeouts changed by setDeadlineTimeouts.
+func (c *wrappedConn) restoreTimeouts(ctx context.Context) error {
+	if c.savedTimeouts == nil {
+		return nil
+	}
+
+	_, err := c.queryRow(ctx, setSessionTimeouts, c.savedTimeouts[0], c.savedTimeouts[1])
+	if err != nil {
+		return err
+	}
+	c.savedTimeouts = nil
+	return nil
+}
+
+func asString(v driver.Value) string {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
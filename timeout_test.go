@@ -0,0 +1,157 @@
+package database_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipki-tech/database"
+	"github.com/sipki-tech/database/connectors"
+)
+
+// timeoutArg matches timeout in milliseconds which isn't greater than max.
+type timeoutArg struct{ max time.Duration }
+
+func (a timeoutArg) Match(v driver.Value) bool {
+	ms, err := strconv.ParseInt(v.(string), 10, 64)
+	return err == nil && ms > 0 && ms <= a.max.Milliseconds()
+}
+
+func TestSQL_DeadlineTimeouts(t *testing.T) {
+	t.Parallel()
+
+	const (
+		setLocal   = "select set_config('statement_timeout', $1, true), set_config('lock_timeout', $1, true)"
+		setSession = "select set_config('statement_timeout', $1, false), set_config('lock_timeout', $2, false)"
+		current    = "select current_setting('statement_timeout'), current_setting('lock_timeout')"
+	)
+	budget := time.Second
+	timeout := timeoutArg{max: budget - database.DefaultDeadlineMargin}
+
+	t.Run("tx", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+		ctx, cancel := context.WithTimeout(context.Background(), budget)
+		defer cancel()
+
+		db, mock := start(t, database.SQLConfig{DeadlineTimeouts: true})
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(setLocal)).WithArgs(timeout).
+			WillReturnRows(sqlmock.NewRows([]string{"a", "b"}).AddRow("1", "1"))
+		mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := db.Tx(ctx, nil, func(tx *sqlx.Tx) error {
+			_, err := tx.ExecContext(ctx, "update")
+			return err
+		})
+		r.NoError(err)
+		r.NoError(mock.ExpectationsWereMet())
+	})
+
+	t.Run("tx_no_deadline", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+		ctx := context.Background()
+
+		db, mock := start(t, database.SQLConfig{DeadlineTimeouts: true})
+		mock.ExpectBegin()
+		mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := db.Tx(ctx, nil, func(tx *sqlx.Tx) error {
+			_, err := tx.ExecContext(ctx, "update")
+			return err
+		})
+		r.NoError(err)
+		r.NoError(mock.ExpectationsWereMet())
+	})
+
+	t.Run("no_tx", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+		ctx, cancel := context.WithTimeout(context.Background(), budget)
+		defer cancel()
+
+		db, mock := start(t, database.SQLConfig{NoTxDeadlineTimeouts: true})
+		mock.ExpectQuery(regexp.QuoteMeta(current)).
+			WillReturnRows(sqlmock.NewRows([]string{"a", "b"}).AddRow([]byte("5s"), "0"))
+		mock.ExpectQuery(regexp.QuoteMeta(setSession)).WithArgs(timeout, timeout).
+			WillReturnRows(sqlmock.NewRows([]string{"a", "b"}).AddRow("1", "1"))
+		mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+		// Timeouts are restored when connection is reused.
+		mock.ExpectQuery(regexp.QuoteMeta(setSession)).WithArgs("5s", "0").
+			WillReturnRows(sqlmock.NewRows([]string{"a", "b"}).AddRow("5s", "0"))
+		mock.ExpectExec("delete").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := db.NoTxContext(ctx, func(ctx context.Context, db *sqlx.DB) error {
+			_, err := db.ExecContext(ctx, "update")
+			return err
+		})
+		r.NoError(err)
+		err = db.NoTx(func(db *sqlx.DB) error {
+			_, err := db.Exec("delete")
+			return err
+		})
+		r.NoError(err)
+		r.NoError(mock.ExpectationsWereMet())
+
+		mock.ExpectClose()
+		r.NoError(db.Close())
+	})
+
+	t.Run("no_tx_disabled", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+		ctx, cancel := context.WithTimeout(context.Background(), budget)
+		defer cancel()
+
+		db, mock := start(t, database.SQLConfig{DeadlineTimeouts: true})
+		mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := db.NoTxContext(ctx, func(ctx context.Context, db *sqlx.DB) error {
+			_, err := db.ExecContext(ctx, "update")
+			return err
+		})
+		r.NoError(err)
+		r.NoError(mock.ExpectationsWereMet())
+	})
+
+	t.Run("prepared_disabled", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+		ctx, cancel := context.WithTimeout(context.Background(), budget)
+		defer cancel()
+
+		_, err := database.NewSQL(ctx, "sqlmock", database.SQLConfig{
+			NoTxDeadlineTimeouts: true,
+			PreparedStatements:   database.PreparedDisabled,
+		}, &connectors.Raw{Query: t.Name()})
+		r.ErrorIs(err, database.ErrSessionTimeouts)
+
+		// Transaction local timeouts are safe with transaction pooling.
+		db, mock := start(t, database.SQLConfig{
+			DeadlineTimeouts:   true,
+			PreparedStatements: database.PreparedDisabled,
+		})
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(setLocal)).WithArgs(timeout).
+			WillReturnRows(sqlmock.NewRows([]string{"a", "b"}).AddRow("1", "1"))
+		mock.ExpectExec("update").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err = db.Tx(ctx, nil, func(tx *sqlx.Tx) error {
+			_, err := tx.ExecContext(ctx, "update")
+			return err
+		})
+		r.NoError(err)
+		r.NoError(mock.ExpectationsWereMet())
+	})
+}